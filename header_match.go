@@ -2,266 +2,3664 @@
 package checkheaders
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // SingleHeader contains a single header keypair
 type SingleHeader struct {
-	Name      string   `json:"name,omitempty"`
-	Values    []string `json:"values,omitempty"`
-	MatchType string   `json:"matchtype,omitempty"`
-	Required  *bool    `json:"required,omitempty"`
-	Contains  *bool    `json:"contains,omitempty"`
-	URLDecode *bool    `json:"urldecode,omitempty"`
-	Debug     *bool    `json:"debug,omitempty"`
-	Regex     *bool    `json:"regex,omitempty"` // New field for regex support
+	Name         string   `json:"name,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	Values       []string `json:"values,omitempty"`
+	MatchType    string   `json:"matchtype,omitempty"`
+	Required     *bool    `json:"required,omitempty"`
+	Contains     *bool    `json:"contains,omitempty"`
+	Prefix       *bool    `json:"prefix,omitempty"`
+	Suffix       *bool    `json:"suffix,omitempty"`
+	URLDecode    *bool    `json:"urldecode,omitempty"`
+	Base64Decode *bool    `json:"base64decode,omitempty"`
+	TrimSpace    *bool    `json:"trimspace,omitempty"`
+	AllValues    *bool    `json:"allvalues,omitempty"`
+	Negate       *bool    `json:"negate,omitempty"`
+	Absent       *bool    `json:"absent,omitempty"`
+	Debug        *bool    `json:"debug,omitempty"`
+	Regex        *bool    `json:"regex,omitempty"` // New field for regex support
+
+	CaseInsensitive     *bool             `json:"caseinsensitive,omitempty"`
+	StatusCode          *int              `json:"statuscode,omitempty"`
+	Secret              *bool             `json:"secret,omitempty"`
+	MinLength           *int              `json:"minlength,omitempty"`
+	MaxLength           *int              `json:"maxlength,omitempty"`
+	Glob                *bool             `json:"glob,omitempty"`
+	CIDR                *bool             `json:"cidr,omitempty"`
+	RejectReason        string            `json:"rejectreason,omitempty"`
+	MinValue            *float64          `json:"minvalue,omitempty"`
+	MaxValue            *float64          `json:"maxvalue,omitempty"`
+	EqualsHeader        string            `json:"equalsheader,omitempty"`
+	OnPassSetHeader     map[string]string `json:"onpasssetheader,omitempty"`
+	RemoveOnPass        *bool             `json:"removeonpass,omitempty"`
+	PathPrefix          string            `json:"pathprefix,omitempty"`
+	PathRegex           string            `json:"pathregex,omitempty"`
+	Methods             []string          `json:"methods,omitempty"`
+	MinMatches          *int              `json:"minmatches,omitempty"`
+	Strict              *bool             `json:"strict,omitempty"`
+	JWTClaim            string            `json:"jwtclaim,omitempty"`
+	Priority            int               `json:"priority,omitempty"`
+	RegexFullMatch      *bool             `json:"regexfullmatch,omitempty"`
+	SplitBy             string            `json:"splitby,omitempty"`
+	SplitIndex          *int              `json:"splitindex,omitempty"`
+	Present             *bool             `json:"present,omitempty"`
+	AllowEmpty          *bool             `json:"allowempty,omitempty"`
+	BasicAuthField      string            `json:"basicauthfield,omitempty"`
+	Enabled             *bool             `json:"enabled,omitempty"`
+	NormalizeUnicode    *bool             `json:"normalizeunicode,omitempty"`
+	Hash                string            `json:"hash,omitempty"`
+	CaptureGroup        *int              `json:"capturegroup,omitempty"`
+	CaptureTo           string            `json:"captureto,omitempty"`
+	DistinctMatches     *bool             `json:"distinctmatches,omitempty"`
+	CaseInsensitiveName *bool             `json:"caseinsensitivename,omitempty"`
+	ValuesFile          string            `json:"valuesfile,omitempty"`
+	ValuesURL           string            `json:"valuesurl,omitempty"`
+	ValuesRefresh       string            `json:"valuesrefresh,omitempty"`
+	SchemePrefix        *bool             `json:"schemeprefix,omitempty"`
+	SplitCommas         *bool             `json:"splitcommas,omitempty"`
+	DebugSampleRate     *float64          `json:"debugsamplerate,omitempty"`
+	IPNormalize         *bool             `json:"ipnormalize,omitempty"`
+	ResponseHeaders     map[string]string `json:"responseheaders,omitempty"`
+	StripChars          string            `json:"stripchars,omitempty"`
+	FoldLocale          string            `json:"foldlocale,omitempty"`
+	Parameter           *string           `json:"parameter,omitempty"`
+	Terminal            *bool             `json:"terminal,omitempty"`
+	Numeric             *bool             `json:"numeric,omitempty"`
+	MaxAge              string            `json:"maxage,omitempty"`
+	TimeFormat          string            `json:"timeformat,omitempty"`
+	ClockSkew           string            `json:"clockskew,omitempty"`
+	HMAC                *HMACConfig       `json:"hmac,omitempty"`
+	RawName             *bool             `json:"rawname,omitempty"`
+	Default             string            `json:"default,omitempty"`
+
+	logger      *slog.Logger
+	regexes     []*regexp.Regexp
+	networks    []*net.IPNet
+	conditions  []numericCondition
+	maxAge      time.Duration
+	clockSkew   time.Duration
+	now         func() time.Time
+	namePattern *regexp.Regexp
+	pathRegex   *regexp.Regexp
+	failClosed  bool
+	template    *template.Template
+	valueSet    map[string]struct{}
+	remote      *remoteValues
+	refresh     time.Duration
+	clientIP    clientIPSource
+	// correlationID is only set on the copy of a rule used for the evaluation of one request
+	correlationID string
+	group         *headerGroup
+}
+
+// HMACConfig defines how the header is verified as the HMAC signature of the request body
+type HMACConfig struct {
+	Secret    string `json:"secret,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+
+	secret []byte
+	hash   func() hash.Hash
+}
+
+// clientIPSource defines where the client IP of the ':clientip' pseudo header is read from
+type clientIPSource struct {
+	header         string
+	trustedProxies int
+}
+
+// remoteValues holds the values fetched from the values URL of a header rule, shared by all copies of the rule
+type remoteValues struct {
+	mu  sync.RWMutex
+	set map[string]struct{}
+}
+
+// contains checks whether the value is one of the last fetched values
+func (r *remoteValues) contains(value string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.set[value]
+	return ok
+}
+
+// store replaces the fetched values
+func (r *remoteValues) store(set map[string]struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.set = set
+}
+
+// Config the plugin configuration.
+type Config struct {
+	Headers            []SingleHeader
+	RejectStatusCode   int    `json:"rejectstatuscode,omitempty"`
+	RejectMessage      string `json:"rejectmessage,omitempty"`
+	RejectContentType  string `json:"rejectcontenttype,omitempty"`
+	Logic              string `json:"logic,omitempty"`
+	RedirectURL        string `json:"redirecturl,omitempty"`
+	RedirectStatusCode int    `json:"redirectstatuscode,omitempty"`
+	DebugFormat        string `json:"debugformat,omitempty"`
+	DebugOutput        string `json:"debugoutput,omitempty"`
+	MissingStatusCode  int    `json:"missingstatuscode,omitempty"`
+	AllowUnsetEnv      *bool  `json:"allowunsetenv,omitempty"`
+	WWWAuthenticate    string `json:"wwwauthenticate,omitempty"`
+	ReportAll          *bool  `json:"reportall,omitempty"`
+	DefaultMatchType   string `json:"defaultmatchtype,omitempty"`
+	MaxValueBytes      int    `json:"maxvaluebytes,omitempty"`
+	OversizeStatusCode int    `json:"oversizestatuscode,omitempty"`
+	DryRun             *bool  `json:"dryrun,omitempty"`
+	FailClosed         *bool  `json:"failclosed,omitempty"`
+	EvalBudget         string `json:"evalbudget,omitempty"`
+	EvalBudgetFailOpen *bool  `json:"evalbudgetfailopen,omitempty"`
+	ClientIPHeader     string `json:"clientipheader,omitempty"`
+	TrustedProxyCount  int    `json:"trustedproxycount,omitempty"`
+	EnforcePercent     *int   `json:"enforcepercent,omitempty"`
+	EnforceHashHeader  string `json:"enforcehashheader,omitempty"`
+	CorrelationHeader  string `json:"correlationheader,omitempty"`
+	AllowEmpty         *bool  `json:"allowempty,omitempty"`
+	TrackLastResult    *bool  `json:"tracklastresult,omitempty"`
+	Mode               string `json:"mode,omitempty"`
+	AuditLog           *bool  `json:"auditlog,omitempty"`
+
+	RejectHeaders map[string]string `json:"rejectheaders,omitempty"`
+
+	RequireAnyOf []HeaderGroup `json:"requireanyof,omitempty"`
+
+	RuleSets map[string][]SingleHeader `json:"rulesets,omitempty"`
+	Selector string                    `json:"selector,omitempty"`
+}
+
+// HeaderGroup contains the names of header rules of which at least one has to match
+type HeaderGroup struct {
+	Name       string   `json:"name,omitempty"`
+	Headers    []string `json:"headers,omitempty"`
+	StatusCode int      `json:"statuscode,omitempty"`
+}
+
+// headerGroup is a validated header group, its rule is reported as failed header if no rule of the group matches
+type headerGroup struct {
+	index int
+	rule  SingleHeader
+}
+
+// IsAllowUnsetEnv checks whether unset environment variables referenced in values should be replaced with an empty string
+func (c *Config) IsAllowUnsetEnv() bool {
+	if c.AllowUnsetEnv == nil || !*c.AllowUnsetEnv {
+		return false
+	}
+
+	return true
+}
+
+// IsReportAll checks whether all header rules should be evaluated to report every failing header
+func (c *Config) IsReportAll() bool {
+	if c.ReportAll == nil || !*c.ReportAll {
+		return false
+	}
+
+	return true
+}
+
+// IsAllowEmpty checks whether a configuration without headers and rule sets should pass all requests instead of failing
+func (c *Config) IsAllowEmpty() bool {
+	if c.AllowEmpty == nil || !*c.AllowEmpty {
+		return false
+	}
+
+	return true
+}
+
+// IsTrackLastResult checks whether the result of the last evaluated request should be kept for LastResult
+func (c *Config) IsTrackLastResult() bool {
+	if c.TrackLastResult == nil || !*c.TrackLastResult {
+		return false
+	}
+
+	return true
+}
+
+// IsAuditLog checks whether one log entry should be written per evaluated request
+func (c *Config) IsAuditLog() bool {
+	if c.AuditLog == nil || !*c.AuditLog {
+		return false
+	}
+
+	return true
+}
+
+// IsDryRun checks whether rejections should only be logged while every request is forwarded
+func (c *Config) IsDryRun() bool {
+	if c.DryRun == nil || !*c.DryRun {
+		return false
+	}
+
+	return true
+}
+
+// IsEvalBudgetFailOpen checks whether requests are allowed once the evaluation budget is exceeded
+func (c *Config) IsEvalBudgetFailOpen() bool {
+	if c.EvalBudgetFailOpen == nil || !*c.EvalBudgetFailOpen {
+		return false
+	}
+
+	return true
+}
+
+// IsFailClosed checks whether internal errors during the evaluation reject the request, defaults to 'true'
+func (c *Config) IsFailClosed() bool {
+	if c.FailClosed == nil || *c.FailClosed {
+		return true
+	}
+
+	return false
+}
+
+// HeaderMatch demonstrates a HeaderMatch plugin.
+type HeaderMatch struct {
+	next              http.Handler
+	headers           []SingleHeader
+	name              string
+	rejectStatusCode  int
+	missingStatusCode int
+	rejectMessage     string
+	rejectContentType string
+	logic             Logic
+	counters          *counters
+	redirectURL       string
+	redirectStatus    int
+	wwwAuthenticate   string
+	reportAll         bool
+	maxValueBytes     int
+	oversizeStatus    int
+	dryRun            bool
+	ruleSets          map[string][]SingleHeader
+	selector          selector
+	evalBudget        time.Duration
+	budgetFailOpen    bool
+	rejectHeaders     http.Header
+	now               func() time.Time
+	enforcePercent    int
+	enforceHeader     string
+	clientIP          clientIPSource
+	correlationHeader string
+	last              *lastResult
+	mode              Mode
+	auditLog          bool
+	groups            []*headerGroup
+}
+
+// lastResult holds the decision of the last evaluated request, including the header which caused a block
+type lastResult struct {
+	mu      sync.Mutex
+	allowed bool
+	header  string
+}
+
+// store replaces the last decision
+func (l *lastResult) store(allowed bool, header string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.allowed = allowed
+	l.header = header
+}
+
+// selector picks the rule set of a request, either by the value of a header or by the longest matching path prefix
+type selector struct {
+	header string
+	path   bool
+}
+
+// Stats contains the number of allowed and blocked requests of a HeaderMatch plugin.
+type Stats struct {
+	Allowed         int64
+	Blocked         int64
+	BlockedByHeader map[string]int64
+}
+
+// metrics publishes the counters of all plugin instances via expvar, keyed by the middleware name
+var metrics = expvar.NewMap("checkheaders")
+
+// counters holds the request counters of a single plugin instance
+type counters struct {
+	allowed         *expvar.Int
+	blocked         *expvar.Int
+	blockedByHeader *expvar.Map
+}
+
+// newCounters creates the counters for the middleware and publishes them, replacing earlier ones with the same name
+func newCounters(name string) *counters {
+	c := &counters{
+		allowed:         new(expvar.Int),
+		blocked:         new(expvar.Int),
+		blockedByHeader: new(expvar.Map).Init(),
+	}
+
+	m := new(expvar.Map).Init()
+	m.Set("allowed_total", c.allowed)
+	m.Set("blocked_total", c.blocked)
+	m.Set("blocked_by_header", c.blockedByHeader)
+	metrics.Set(name, m)
+
+	return c
+}
+
+// MatchType defines an enum which can be used to specify the match type for the 'contains' config.
+type MatchType string
+
+const (
+	//MatchAll requires all values to be matched
+	MatchAll MatchType = "all"
+	//MatchOne requires only one value to be matched
+	MatchOne MatchType = "one"
+	//MatchNone requires none of the values to be matched
+	MatchNone MatchType = "none"
+)
+
+// Source defines an enum which can be used to specify where the value of a rule is read from.
+type Source string
+
+const (
+	//SourceHeader reads the value from the request headers
+	SourceHeader Source = "header"
+	//SourceQuery reads the value from the URL query parameters
+	SourceQuery Source = "query"
+	//SourceCookie reads the value from the request cookies
+	SourceCookie Source = "cookie"
+	//SourcePath reads the value from the escaped URL path, the name is only used as label
+	SourcePath Source = "path"
+	//SourceRawQuery reads the value from the raw URL query, the name is only used as label
+	SourceRawQuery Source = "rawquery"
+	//SourceForm reads the value from a field of an application/x-www-form-urlencoded request body
+	SourceForm Source = "form"
+)
+
+const (
+	//PseudoHeaderHost resolves to the host of the request, including the port if present
+	PseudoHeaderHost = ":host"
+	//PseudoHeaderMethod resolves to the method of the request
+	PseudoHeaderMethod = ":method"
+	//PseudoHeaderTLSCN resolves to the common name of the TLS client certificate
+	PseudoHeaderTLSCN = ":tls.cn"
+	//PseudoHeaderTLSSAN resolves to the subject alternative names of the TLS client certificate, one value per name
+	PseudoHeaderTLSSAN = ":tls.san"
+	//PseudoHeaderClientIP resolves to the client IP, read from the client IP header behind the trusted proxies
+	PseudoHeaderClientIP = ":clientip"
+)
+
+// result describes the outcome of a header rule
+type result int
+
+const (
+	//resultFailed means the header rule is not satisfied
+	resultFailed result = iota
+	//resultMatched means the header value matched the header rule
+	resultMatched
+	//resultAbsentAllowed means the header is absent or empty, which the header rule allows
+	resultAbsentAllowed
+	//resultError means the header rule could not be evaluated, the request is rejected regardless of the logic
+	resultError
+)
+
+// resultOf converts the outcome of a match into a result
+func resultOf(matched bool) result {
+	if matched {
+		return resultMatched
+	}
+
+	return resultFailed
+}
+
+// passed checks whether the result satisfies the header rule
+func (r result) passed() bool {
+	return r != resultFailed && r != resultError
+}
+
+// negate inverts the result, a negated failure counts as a match while errors are kept
+func (r result) negate() result {
+	if r == resultError {
+		return r
+	}
+	if r.passed() {
+		return resultFailed
+	}
+
+	return resultMatched
+}
+
+// block inverts the result for the block mode, in which a matching rule rejects the request.
+// Absent headers which are allowed to be absent and errors are kept, as there is nothing to block or nothing known.
+func (r result) block() result {
+	switch r {
+	case resultMatched:
+		return resultFailed
+	case resultFailed:
+		return resultMatched
+	default:
+		return r
+	}
+}
+
+func (r result) String() string {
+	switch r {
+	case resultMatched:
+		return "matched"
+	case resultAbsentAllowed:
+		return "absent-allowed"
+	case resultError:
+		return "error"
+	default:
+		return "failed"
+	}
+}
+
+// Logic defines an enum which can be used to specify how the results of the header rules are combined.
+type Logic string
+
+const (
+	//LogicAnd requires all header rules to pass
+	LogicAnd Logic = "and"
+	//LogicOr requires only one header rule to pass
+	LogicOr Logic = "or"
+)
+
+// Mode defines an enum which can be used to specify whether matching header rules allow or block a request.
+type Mode string
+
+const (
+	//ModeAllow allows requests whose header rules match
+	ModeAllow Mode = "allow"
+	//ModeBlock blocks requests whose header rules match
+	ModeBlock Mode = "block"
+)
+
+// ConfigError describes an incorrect configuration found when creating or validating the plugin.
+type ConfigError struct {
+	// HeaderName is the name of the header rule, empty for global settings
+	HeaderName string
+	// Field is the name of the incorrect setting as used in the configuration, e.g. "matchtype"
+	Field string
+	// Reason describes what is incorrect, without the header name
+	Reason string
+
+	message string
+	err     error
+}
+
+func (e *ConfigError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the error which caused the configuration error, if any
+func (e *ConfigError) Unwrap() error {
+	return e.err
+}
+
+// newConfigError creates a ConfigError whose message is formatted like fmt.Errorf,
+// the reason is the message without the common prefix and header name
+func newConfigError(headerName string, field string, format string, args ...any) *ConfigError {
+	err := fmt.Errorf(format, args...)
+
+	reason := strings.TrimPrefix(err.Error(), "configuration incorrect")
+	if headerName != "" {
+		reason = strings.TrimPrefix(reason, " for header "+headerName)
+	}
+	reason = strings.TrimLeft(reason, ",: ")
+
+	return &ConfigError{HeaderName: headerName, Field: field, Reason: reason, message: err.Error(), err: errors.Unwrap(err)}
+}
+
+// inRuleSet adds the rule set to the message of a configuration error of one of its headers
+func inRuleSet(err error, key string) error {
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		return fmt.Errorf("configuration incorrect for rule set %v: %w", key, err)
+	}
+
+	return &ConfigError{
+		HeaderName: configErr.HeaderName,
+		Field:      configErr.Field,
+		Reason:     configErr.Reason,
+		message:    fmt.Sprintf("configuration incorrect for rule set %v: %v", key, err),
+		err:        err,
+	}
+}
+
+// CreateConfig creates the default plugin configuration.
+func CreateConfig() *Config {
+	return &Config{
+		Headers: []SingleHeader{},
+	}
+}
+
+// logger is used for the debug output, the logger of the configured debug format and output is used when unset
+var logger *slog.Logger
+
+// debugWriter overrides the configured debug output of plugin instances created afterwards
+var debugWriter io.Writer
+
+// SetDebugWriter sets the destination of the debug output for plugin instances created afterwards,
+// taking precedence over the configured debug output. Passing nil restores the configured output.
+func SetDebugWriter(w io.Writer) {
+	debugWriter = w
+}
+
+// SetLogger sets the logger used for the debug output and the audit log, taking precedence over the configured debug format.
+// Passing nil restores the default. It should be called before any plugin instance serves requests.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// debugLog writes a structured debug entry for the header rule
+func debugLog(msg string, vHeader *SingleHeader, requestValue string, attrs ...any) {
+	l := loggerFor(vHeader)
+
+	attrs = append([]any{
+		slog.String("header", vHeader.Name),
+		slog.String("requestValue", requestValue),
+		slog.Any("configuredValues", vHeader.Values),
+		slog.String("matchType", vHeader.MatchType),
+	}, attrs...)
+	if vHeader.correlationID != "" {
+		attrs = append(attrs, slog.String("correlationId", vHeader.correlationID))
+	}
+	l.Info("checkheaders (debug): "+msg, attrs...)
+}
+
+// loggerFor returns the logger set by SetLogger, falling back to the debug logger of the header rule and slog.Default
+func loggerFor(vHeader *SingleHeader) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	if vHeader.logger != nil {
+		return vHeader.logger
+	}
+
+	return slog.Default()
+}
+
+// dryRunLog logs a request which would have been rejected by the header rule
+func dryRunLog(req *http.Request, vHeader *SingleHeader) {
+	loggerFor(vHeader).Warn("checkheaders (dry run): Request would have been rejected",
+		slog.String("header", vHeader.Name),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+	)
+}
+
+// notEnforcedLog logs a request which was rejected by the header rule but allowed as it is outside of the enforced percentage
+func notEnforcedLog(req *http.Request, vHeader *SingleHeader) {
+	loggerFor(vHeader).Warn("checkheaders (rollout): Request would have been rejected but is not enforced",
+		slog.String("header", vHeader.Name),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+	)
+}
+
+// envPattern matches ${ENV_VAR} references in configured values
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${ENV_VAR} references in the values with the value of the environment variable
+// unset variables are an error unless allowUnset is true, in which case they are replaced with an empty string
+func expandEnv(values []string, allowUnset bool) ([]string, error) {
+	expanded := make([]string, 0, len(values))
+	for _, value := range values {
+		var err error
+		value = envPattern.ReplaceAllStringFunc(value, func(ref string) string {
+			name := envPattern.FindStringSubmatch(ref)[1]
+			envValue, ok := os.LookupEnv(name)
+			if !ok && !allowUnset && err == nil {
+				err = fmt.Errorf("environment variable %v is not set", name)
+			}
+			return envValue
+		})
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, value)
+	}
+
+	return expanded, nil
+}
+
+// dedupe removes duplicate values, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		unique = append(unique, value)
+	}
+
+	return unique
+}
+
+// New created a new HeaderMatch plugin.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	return NewWithOptions(ctx, next, config, name, Options{})
+}
+
+// Options contains settings of a HeaderMatch plugin which can not be configured through Traefik,
+// mainly to make the debug output and time based behavior deterministic in tests.
+type Options struct {
+	// DebugWriter overrides the configured debug output and the writer set by SetDebugWriter
+	DebugWriter io.Writer
+	// Now is used instead of time.Now for the evaluation budget, the max age of timestamps and the timestamps of the debug output
+	Now func() time.Time
+}
+
+// NewWithOptions creates a new HeaderMatch plugin like New, using the given options.
+func NewWithOptions(ctx context.Context, next http.Handler, config *Config, name string, opts Options) (http.Handler, error) {
+	a, err := newHeaderMatch(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.startRemoteValues(ctx); err != nil {
+		return nil, err
+	}
+
+	a.next = next
+	a.name = name
+	a.counters = newCounters(name)
+
+	return a, nil
+}
+
+// Validate checks the configuration the same way New does, including the compilation of all patterns,
+// without creating the plugin. It can be used to check configurations before they are deployed.
+func Validate(config *Config) error {
+	_, err := newHeaderMatch(config, Options{})
+	return err
+}
+
+// newHeaderMatch validates the configuration and creates a HeaderMatch plugin without the next handler and metrics
+func newHeaderMatch(config *Config, opts Options) (*HeaderMatch, error) {
+	// a plugin without rules passes every request, which is most likely a mistake unless explicitly allowed
+	if len(config.Headers) == 0 && len(config.RuleSets) == 0 && !config.IsAllowEmpty() {
+		return nil, newConfigError("", "headers", "configuration incorrect, missing headers: configure at least one entry in 'headers' or 'rulesets', or set 'allowempty' to pass all requests until headers are configured")
+	}
+
+	rejectStatusCode := http.StatusForbidden
+	if config.RejectStatusCode != 0 {
+		if !isRejectStatusCode(config.RejectStatusCode) {
+			return nil, newConfigError("", "rejectstatuscode", "configuration incorrect, reject status code %d must be a 4xx or 5xx code", config.RejectStatusCode)
+		}
+		rejectStatusCode = config.RejectStatusCode
+	}
+
+	missingStatusCode := rejectStatusCode
+	if config.MissingStatusCode != 0 {
+		if !isRejectStatusCode(config.MissingStatusCode) {
+			return nil, newConfigError("", "missingstatuscode", "configuration incorrect, missing status code %d must be a 4xx or 5xx code", config.MissingStatusCode)
+		}
+		missingStatusCode = config.MissingStatusCode
+	}
+
+	rejectContentType := "text/plain; charset=utf-8"
+	if strings.TrimSpace(config.RejectContentType) != "" {
+		rejectContentType = config.RejectContentType
+	}
+
+	rejectMessage := config.RejectMessage
+	if rejectMessage == "" {
+		if strings.HasPrefix(rejectContentType, "application/json") {
+			rejectMessage = `{"error":"forbidden"}`
+		} else {
+			rejectMessage = "Not allowed"
+		}
+	}
+
+	redirectStatus := http.StatusFound
+	if config.RedirectURL != "" {
+		if _, err := url.Parse(config.RedirectURL); err != nil {
+			return nil, newConfigError("", "redirecturl", "configuration incorrect, invalid redirect url: %w", err)
+		}
+		if config.RejectMessage != "" || config.RejectContentType != "" {
+			return nil, newConfigError("", "redirecturl", "configuration incorrect, redirect url can not be combined with a reject message or content type")
+		}
+		if config.RedirectStatusCode != 0 {
+			if config.RedirectStatusCode < 300 || config.RedirectStatusCode > 399 {
+				return nil, newConfigError("", "redirectstatuscode", "configuration incorrect, redirect status code %d must be a 3xx code", config.RedirectStatusCode)
+			}
+			redirectStatus = config.RedirectStatusCode
+		}
+	}
+
+	if config.MaxValueBytes < 0 {
+		return nil, newConfigError("", "maxvaluebytes", "configuration incorrect, max value bytes must not be negative")
+	}
+	oversizeStatus := http.StatusRequestHeaderFieldsTooLarge
+	if config.OversizeStatusCode != 0 {
+		if !isRejectStatusCode(config.OversizeStatusCode) {
+			return nil, newConfigError("", "oversizestatuscode", "configuration incorrect, oversize status code %d must be a 4xx or 5xx code", config.OversizeStatusCode)
+		}
+		oversizeStatus = config.OversizeStatusCode
+	}
+
+	var evalBudget time.Duration
+	if config.EvalBudget != "" {
+		var err error
+		evalBudget, err = time.ParseDuration(config.EvalBudget)
+		if err != nil {
+			return nil, newConfigError("", "evalbudget", "configuration incorrect, invalid evaluation budget: %w", err)
+		}
+		if evalBudget <= 0 {
+			return nil, newConfigError("", "evalbudget", "configuration incorrect, evaluation budget %v must be positive", config.EvalBudget)
+		}
+	}
+
+	if config.TrustedProxyCount < 0 {
+		return nil, newConfigError("", "trustedproxycount", "configuration incorrect, trusted proxy count %d must not be negative", config.TrustedProxyCount)
+	}
+
+	enforcePercent := 100
+	if config.EnforcePercent != nil {
+		if *config.EnforcePercent < 0 || *config.EnforcePercent > 100 {
+			return nil, newConfigError("", "enforcepercent", "configuration incorrect, enforce percent %d must be between 0 and 100", *config.EnforcePercent)
+		}
+		enforcePercent = *config.EnforcePercent
+	} else if config.EnforceHashHeader != "" {
+		return nil, newConfigError("", "enforcehashheader", "configuration incorrect, enforcehashheader can only be used in combination with 'enforcepercent'")
+	}
+
+	var last *lastResult
+	if config.IsTrackLastResult() {
+		last = &lastResult{}
+	}
+
+	var rejectHeaders http.Header
+	for name, value := range config.RejectHeaders {
+		if strings.TrimSpace(name) == "" {
+			return nil, newConfigError("", "rejectheaders", "configuration incorrect, reject header name must not be empty")
+		}
+		if rejectHeaders == nil {
+			rejectHeaders = http.Header{}
+		}
+		rejectHeaders.Set(name, value)
+	}
+
+	var debugOutput io.Writer
+	switch strings.ToLower(config.DebugOutput) {
+	case "", "stdout":
+		debugOutput = os.Stdout
+	case "stderr":
+		debugOutput = os.Stderr
+	default:
+		return nil, newConfigError("", "debugoutput", "configuration incorrect, unknown debug output %v", config.DebugOutput)
+	}
+	if opts.DebugWriter != nil {
+		debugOutput = opts.DebugWriter
+	} else if debugWriter != nil {
+		debugOutput = debugWriter
+	}
+
+	now := opts.Now
+	var handlerOptions *slog.HandlerOptions
+	if now != nil {
+		handlerOptions = &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+				if len(groups) == 0 && attr.Key == slog.TimeKey {
+					attr.Value = slog.TimeValue(now())
+				}
+				return attr
+			},
+		}
+	} else {
+		now = time.Now
+	}
+
+	var debugLogger *slog.Logger
+	switch strings.ToLower(config.DebugFormat) {
+	case "", "text":
+		debugLogger = slog.New(slog.NewTextHandler(debugOutput, handlerOptions))
+	case "json":
+		debugLogger = slog.New(slog.NewJSONHandler(debugOutput, handlerOptions))
+	default:
+		return nil, newConfigError("", "debugformat", "configuration incorrect, unknown debug format %v", config.DebugFormat)
+	}
+
+	logic := LogicAnd
+	if strings.TrimSpace(config.Logic) != "" {
+		logic = Logic(strings.ToLower(config.Logic))
+		if logic != LogicAnd && logic != LogicOr {
+			return nil, newConfigError("", "logic", "configuration incorrect, unknown logic %v", config.Logic)
+		}
+	}
+
+	mode := ModeAllow
+	if strings.TrimSpace(config.Mode) != "" {
+		mode = Mode(strings.ToLower(config.Mode))
+		if mode != ModeAllow && mode != ModeBlock {
+			return nil, newConfigError("", "mode", "configuration incorrect, unknown mode %v", config.Mode)
+		}
+	}
+
+	if config.DefaultMatchType != "" && !isMatchType(config.DefaultMatchType) {
+		return nil, newConfigError("", "defaultmatchtype", "configuration incorrect, unknown default match type %v", config.DefaultMatchType)
+	}
+
+	headers, err := newHeaders(config.Headers, config, debugLogger, now)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := newGroups(config, headers, rejectStatusCode, logic, mode, debugLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := newSelector(config)
+	if err != nil {
+		return nil, err
+	}
+	ruleSets := make(map[string][]SingleHeader, len(config.RuleSets))
+	for key, ruleSet := range config.RuleSets {
+		if len(ruleSet) == 0 {
+			return nil, newConfigError("", "rulesets", "configuration incorrect, missing headers for rule set %v", key)
+		}
+		ruleSets[key], err = newHeaders(ruleSet, config, debugLogger, now)
+		if err != nil {
+			return nil, inRuleSet(err, key)
+		}
+	}
+
+	return &HeaderMatch{
+		headers:           headers,
+		rejectStatusCode:  rejectStatusCode,
+		missingStatusCode: missingStatusCode,
+		rejectMessage:     rejectMessage,
+		rejectContentType: rejectContentType,
+		logic:             logic,
+		redirectURL:       config.RedirectURL,
+		redirectStatus:    redirectStatus,
+		wwwAuthenticate:   config.WWWAuthenticate,
+		reportAll:         config.IsReportAll(),
+		maxValueBytes:     config.MaxValueBytes,
+		oversizeStatus:    oversizeStatus,
+		dryRun:            config.IsDryRun(),
+		ruleSets:          ruleSets,
+		selector:          selector,
+		evalBudget:        evalBudget,
+		budgetFailOpen:    config.IsEvalBudgetFailOpen(),
+		rejectHeaders:     rejectHeaders,
+		now:               now,
+		enforcePercent:    enforcePercent,
+		enforceHeader:     config.EnforceHashHeader,
+		clientIP:          newClientIPSource(config),
+		correlationHeader: config.CorrelationHeader,
+		last:              last,
+		mode:              mode,
+		auditLog:          config.IsAuditLog(),
+		groups:            groups,
+	}, nil
+}
+
+// newGroups validates the header groups and assigns the header rules to their group
+func newGroups(config *Config, headers []SingleHeader, rejectStatusCode int, logic Logic, mode Mode, debugLogger *slog.Logger) ([]*headerGroup, error) {
+	if len(config.RequireAnyOf) == 0 {
+		return nil, nil
+	}
+	// with 'or' logic every rule already behaves like a group, rule sets would need groups per rule set
+	if logic != LogicAnd || mode != ModeAllow || len(config.RuleSets) > 0 {
+		return nil, newConfigError("", "requireanyof", "configuration incorrect, requireanyof can only be used with 'and' logic in 'allow' mode and without 'rulesets'")
+	}
+
+	groups := make([]*headerGroup, 0, len(config.RequireAnyOf))
+	names := make(map[string]struct{}, len(config.RequireAnyOf))
+	for i, configGroup := range config.RequireAnyOf {
+		if strings.TrimSpace(configGroup.Name) == "" {
+			return nil, newConfigError("", "requireanyof", "configuration incorrect, missing name of group %d", i)
+		}
+		if _, ok := names[configGroup.Name]; ok {
+			return nil, newConfigError("", "requireanyof", "configuration incorrect, group %v is configured more than once", configGroup.Name)
+		}
+		names[configGroup.Name] = struct{}{}
+		if len(configGroup.Headers) == 0 {
+			return nil, newConfigError("", "requireanyof", "configuration incorrect for group %v, missing headers", configGroup.Name)
+		}
+		statusCode := rejectStatusCode
+		if configGroup.StatusCode != 0 {
+			if !isRejectStatusCode(configGroup.StatusCode) {
+				return nil, newConfigError("", "requireanyof", "configuration incorrect for group %v, status code %d must be a 4xx or 5xx code", configGroup.Name, configGroup.StatusCode)
+			}
+			statusCode = configGroup.StatusCode
+		}
+
+		group := &headerGroup{
+			index: i,
+			rule:  SingleHeader{Name: configGroup.Name, StatusCode: &statusCode, logger: debugLogger},
+		}
+		for _, name := range configGroup.Headers {
+			found := false
+			for j := range headers {
+				if headers[j].Name != name {
+					continue
+				}
+				if headers[j].group != nil && headers[j].group != group {
+					return nil, newConfigError(name, "requireanyof", "configuration incorrect for group %v, header %v is already part of group %v", configGroup.Name, name, headers[j].group.rule.Name)
+				}
+				headers[j].group = group
+				found = true
+			}
+			if !found {
+				return nil, newConfigError(name, "requireanyof", "configuration incorrect for group %v, unknown header %v", configGroup.Name, name)
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// newSelector parses the selector of the rule sets, which is either 'path' or 'header:<name>'
+func newSelector(config *Config) (selector, error) {
+	if len(config.RuleSets) == 0 {
+		if config.Selector != "" {
+			return selector{}, newConfigError("", "selector", "configuration incorrect, selector can only be used in combination with rule sets")
+		}
+		return selector{}, nil
+	}
+
+	kind, header, _ := strings.Cut(config.Selector, ":")
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "path":
+		return selector{path: true}, nil
+	case "header":
+		header = strings.TrimSpace(header)
+		if header == "" {
+			return selector{}, newConfigError("", "selector", "configuration incorrect, missing header name for selector %v", config.Selector)
+		}
+		return selector{header: header}, nil
+	case "":
+		return selector{}, newConfigError("", "selector", "configuration incorrect, missing selector for rule sets")
+	default:
+		return selector{}, newConfigError("", "selector", "configuration incorrect, unknown selector %v", config.Selector)
+	}
+}
+
+// selectHeaders returns the header rules for the request, the default headers are used if no rule set is selected
+func (a *HeaderMatch) selectHeaders(req *http.Request) []SingleHeader {
+	if len(a.ruleSets) == 0 {
+		return a.headers
+	}
+
+	if a.selector.header != "" {
+		if headers, ok := a.ruleSets[req.Header.Get(a.selector.header)]; ok {
+			return headers
+		}
+		return a.headers
+	}
+
+	headers := a.headers
+	longest := -1
+	for prefix, ruleSet := range a.ruleSets {
+		if strings.HasPrefix(req.URL.Path, prefix) && len(prefix) > longest {
+			headers = ruleSet
+			longest = len(prefix)
+		}
+	}
+
+	return headers
+}
+
+// newHeaders validates the configured header rules and prepares them for the evaluation
+func newHeaders(configHeaders []SingleHeader, config *Config, debugLogger *slog.Logger, now func() time.Time) ([]SingleHeader, error) {
+	headers := make([]SingleHeader, 0, len(configHeaders))
+	ruleNames := make(map[string]string, len(configHeaders))
+	for _, vHeader := range configHeaders {
+		// disabled rules are neither validated nor evaluated, so they may be incomplete
+		if !vHeader.IsEnabled() {
+			continue
+		}
+		if strings.TrimSpace(vHeader.Name) == "" {
+			return nil, newConfigError("", "name", "configuration incorrect, missing header name")
+		}
+		// combining different checks for one header is fine, the same check twice is most likely a copy-paste mistake
+		key := vHeader.ruleKey()
+		if name, ok := ruleNames[key]; ok {
+			return nil, newConfigError(vHeader.Name, "name", "configuration incorrect, header %v is configured more than once for the same %v check, previously as %v", vHeader.Name, vHeader.matchMode(), name)
+		}
+		ruleNames[key] = vHeader.Name
+		if strings.TrimSpace(vHeader.MatchType) == "" {
+			vHeader.MatchType = config.DefaultMatchType
+		}
+		if len(vHeader.Values) > 0 || vHeader.ValuesFile != "" {
+			values, err := expandEnv(vHeader.Values, config.IsAllowUnsetEnv())
+			if err != nil {
+				return nil, newConfigError(vHeader.Name, "values", "configuration incorrect for header %v: %w", vHeader.Name, err)
+			}
+			if vHeader.ValuesFile != "" {
+				fileValues, err := readValuesFile(vHeader.ValuesFile)
+				if err != nil {
+					return nil, newConfigError(vHeader.Name, "valuesfile", "configuration incorrect for header %v, can not read values file: %w", vHeader.Name, err)
+				}
+				values = append(values, fileValues...)
+			}
+			if vHeader.IsNormalizeUnicode() {
+				for i, value := range values {
+					values[i] = normalizeUnicode(value)
+				}
+			}
+			if vHeader.StripChars != "" {
+				for i, value := range values {
+					values[i] = stripChars(value, vHeader.StripChars)
+				}
+			}
+			// duplicates would be counted more than once by the match types
+			vHeader.Values = dedupe(values)
+		}
+		if len(vHeader.Values) == 0 {
+			if vHeader.requiresValues() && vHeader.ValuesURL == "" {
+				return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, missing header values")
+			}
+		} else {
+			for _, value := range vHeader.Values {
+				if strings.TrimSpace(value) == "" {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, empty value found")
+				}
+			}
+		}
+		// only the first mode in the order of matchMode would be used, the others silently ignored
+		if modes := vHeader.modeFlags(); len(modes) > 1 {
+			return nil, newConfigError(vHeader.Name, modes[len(modes)-1], "configuration incorrect for header %v, only one of 'contains', 'prefix', 'suffix', 'regex', 'glob', 'cidr' and 'numeric' can be set, got '%v'", vHeader.Name, strings.Join(modes, "' and '"))
+		}
+		if !vHeader.IsContains() && !vHeader.IsPrefix() && !vHeader.IsSuffix() && !vHeader.IsCIDR() && !vHeader.IsNumeric() && vHeader.MatchType == string(MatchAll) {
+			return nil, newConfigError(vHeader.Name, "matchtype", "configuration incorrect for header %v %s", vHeader.Name, ", matchall can only be used in combination with 'contains', 'prefix', 'suffix', 'cidr' or 'numeric'")
+		}
+		if strings.TrimSpace(vHeader.MatchType) == "" && (len(vHeader.Values) > 0 || vHeader.ValuesURL != "" || vHeader.requiresValues()) {
+			return nil, newConfigError(vHeader.Name, "matchtype", "configuration incorrect, missing match type configuration for header %v", vHeader.Name)
+		}
+		if vHeader.MatchType != "" && !isMatchType(vHeader.MatchType) {
+			return nil, newConfigError(vHeader.Name, "matchtype", "configuration incorrect for header %v, unknown match type %v", vHeader.Name, vHeader.MatchType)
+		}
+		if (vHeader.MinLength != nil && *vHeader.MinLength < 0) || (vHeader.MaxLength != nil && *vHeader.MaxLength < 0) {
+			return nil, newConfigError(vHeader.Name, "minlength", "configuration incorrect for header %v, length bounds must not be negative", vHeader.Name)
+		}
+		if vHeader.MinLength != nil && vHeader.MaxLength != nil && *vHeader.MinLength > *vHeader.MaxLength {
+			return nil, newConfigError(vHeader.Name, "minlength", "configuration incorrect for header %v, minimum length is greater than maximum length", vHeader.Name)
+		}
+		if vHeader.MinMatches != nil {
+			// exact and cidr matches do not count the matching values
+			if !vHeader.IsContains() && !vHeader.IsPrefix() && !vHeader.IsSuffix() && !vHeader.IsRegex() && !vHeader.IsGlob() && !vHeader.IsNumeric() {
+				return nil, newConfigError(vHeader.Name, "minmatches", "configuration incorrect for header %v, minmatches can only be used in combination with 'contains', 'prefix', 'suffix', 'regex', 'glob' or 'numeric'", vHeader.Name)
+			}
+			if vHeader.MatchType == string(MatchNone) {
+				return nil, newConfigError(vHeader.Name, "minmatches", "configuration incorrect for header %v, minmatches can not be combined with matchtype none", vHeader.Name)
+			}
+			if *vHeader.MinMatches < 1 || *vHeader.MinMatches > len(vHeader.Values) {
+				return nil, newConfigError(vHeader.Name, "minmatches", "configuration incorrect for header %v, minmatches must be between 1 and the number of values", vHeader.Name)
+			}
+		}
+		if vHeader.MinValue != nil && vHeader.MaxValue != nil && *vHeader.MinValue > *vHeader.MaxValue {
+			return nil, newConfigError(vHeader.Name, "minvalue", "configuration incorrect for header %v, minimum value is greater than maximum value", vHeader.Name)
+		}
+		switch Source(vHeader.Source) {
+		case "", SourceHeader, SourceQuery, SourceCookie, SourcePath, SourceRawQuery, SourceForm:
+		default:
+			if strings.Contains(vHeader.Source, "{{") {
+				tmpl, err := template.New(vHeader.Name).Option("missingkey=error").Parse(vHeader.Source)
+				if err != nil {
+					return nil, newConfigError(vHeader.Name, "source", "configuration incorrect, invalid source template for header %v: %w", vHeader.Name, err)
+				}
+				vHeader.template = tmpl
+				break
+			}
+			return nil, newConfigError(vHeader.Name, "source", "configuration incorrect for header %v, unknown source %v", vHeader.Name, vHeader.Source)
+		}
+		if isHeaderSource(&vHeader) && strings.HasPrefix(vHeader.Name, ":") {
+			switch strings.ToLower(vHeader.Name) {
+			case PseudoHeaderHost, PseudoHeaderMethod, PseudoHeaderTLSCN, PseudoHeaderTLSSAN, PseudoHeaderClientIP:
+				vHeader.Name = strings.ToLower(vHeader.Name)
+			default:
+				return nil, newConfigError(vHeader.Name, "name", "configuration incorrect, unknown pseudo header %v", vHeader.Name)
+			}
+		}
+		if vHeader.StatusCode != nil && !isRejectStatusCode(*vHeader.StatusCode) {
+			return nil, newConfigError(vHeader.Name, "statuscode", "configuration incorrect for header %v, status code %d must be a 4xx or 5xx code", vHeader.Name, *vHeader.StatusCode)
+		}
+		for name := range vHeader.ResponseHeaders {
+			if strings.TrimSpace(name) == "" {
+				return nil, newConfigError(vHeader.Name, "responseheaders", "configuration incorrect for header %v, response header name must not be empty", vHeader.Name)
+			}
+		}
+		if vHeader.IsPresent() && vHeader.IsAbsent() {
+			return nil, newConfigError(vHeader.Name, "present", "configuration incorrect for header %v, present can not be combined with absent", vHeader.Name)
+		}
+		if vHeader.BasicAuthField != "" {
+			if vHeader.BasicAuthField != "username" && vHeader.BasicAuthField != "password" {
+				return nil, newConfigError(vHeader.Name, "basicauthfield", "configuration incorrect for header %v, unknown basic auth field %v", vHeader.Name, vHeader.BasicAuthField)
+			}
+			if !isHeaderSource(&vHeader) || http.CanonicalHeaderKey(vHeader.Name) != "Authorization" {
+				return nil, newConfigError(vHeader.Name, "basicauthfield", "configuration incorrect for header %v, basicauthfield can only be used with the Authorization header", vHeader.Name)
+			}
+		}
+		if vHeader.Hash != "" {
+			if vHeader.Hash != "sha256" {
+				return nil, newConfigError(vHeader.Name, "hash", "configuration incorrect for header %v, unknown hash algorithm %v", vHeader.Name, vHeader.Hash)
+			}
+			if vHeader.IsContains() || vHeader.IsPrefix() || vHeader.IsSuffix() || vHeader.IsRegex() || vHeader.IsGlob() || vHeader.IsCIDR() || vHeader.IsNumeric() {
+				return nil, newConfigError(vHeader.Name, "hash", "configuration incorrect for header %v, hash can only be used for exact matches", vHeader.Name)
+			}
+			// the request value would be hashed in lower case, so the configured hash would silently have to match that
+			if vHeader.IsCaseInsensitive() {
+				return nil, newConfigError(vHeader.Name, "hash", "configuration incorrect for header %v, hash can not be combined with 'caseinsensitive'", vHeader.Name)
+			}
+			for i, value := range vHeader.Values {
+				// hex digests are compared in lower case
+				value = strings.ToLower(value)
+				if digest, err := hex.DecodeString(value); err != nil || len(digest) != sha256.Size {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect for header %v, value %d is not a hex encoded sha256 hash", vHeader.Name, i)
+				}
+				vHeader.Values[i] = value
+			}
+		}
+		if vHeader.IsSchemePrefix() && (vHeader.SplitBy != "" || vHeader.BasicAuthField != "") {
+			return nil, newConfigError(vHeader.Name, "schemeprefix", "configuration incorrect for header %v, schemeprefix can not be combined with 'splitby' or 'basicauthfield'", vHeader.Name)
+		}
+		if vHeader.Parameter != nil && (vHeader.SplitBy != "" || vHeader.IsSplitCommas() || vHeader.IsSchemePrefix() || vHeader.BasicAuthField != "") {
+			return nil, newConfigError(vHeader.Name, "parameter", "configuration incorrect for header %v, parameter can not be combined with 'splitby', 'splitcommas', 'schemeprefix' or 'basicauthfield'", vHeader.Name)
+		}
+		// the headers are compared as they are sent, only case folding applies
+		if vHeader.EqualsHeader != "" {
+			if !isHeaderSource(&vHeader) || strings.HasPrefix(vHeader.Name, ":") || strings.Contains(vHeader.Name, "*") {
+				return nil, newConfigError(vHeader.Name, "equalsheader", "configuration incorrect for header %v, equalsheader can only be used for request headers without pattern", vHeader.Name)
+			}
+			if vHeader.IsURLDecode() || vHeader.IsBase64Decode() || vHeader.IsTrimSpace() || vHeader.IsNormalizeUnicode() || vHeader.StripChars != "" || vHeader.SplitBy != "" || vHeader.Parameter != nil || vHeader.IsSchemePrefix() || vHeader.JWTClaim != "" || vHeader.IsIPNormalize() {
+				return nil, newConfigError(vHeader.Name, "equalsheader", "configuration incorrect for header %v, equalsheader can not be combined with 'urldecode', 'base64decode', 'trimspace', 'normalizeunicode', 'stripchars', 'splitby', 'parameter', 'schemeprefix', 'jwtclaim' or 'ipnormalize'", vHeader.Name)
+			}
+		}
+		if vHeader.IsSplitCommas() && (vHeader.SplitBy != "" || vHeader.BasicAuthField != "" || vHeader.EqualsHeader != "") {
+			return nil, newConfigError(vHeader.Name, "splitcommas", "configuration incorrect for header %v, splitcommas can not be combined with 'splitby', 'basicauthfield' or 'equalsheader'", vHeader.Name)
+		}
+		if vHeader.DebugSampleRate != nil {
+			if !vHeader.IsDebug() {
+				return nil, newConfigError(vHeader.Name, "debugsamplerate", "configuration incorrect for header %v, debugsamplerate can only be used in combination with 'debug'", vHeader.Name)
+			}
+			if *vHeader.DebugSampleRate < 0 || *vHeader.DebugSampleRate > 1 {
+				return nil, newConfigError(vHeader.Name, "debugsamplerate", "configuration incorrect for header %v, debug sample rate %v must be between 0 and 1", vHeader.Name, *vHeader.DebugSampleRate)
+			}
+		}
+		if vHeader.SplitIndex != nil && vHeader.SplitBy == "" {
+			return nil, newConfigError(vHeader.Name, "splitindex", "configuration incorrect for header %v, splitindex can only be used in combination with 'splitby'", vHeader.Name)
+		}
+		if vHeader.CaptureGroup != nil && vHeader.CaptureTo == "" {
+			return nil, newConfigError(vHeader.Name, "capturegroup", "configuration incorrect for header %v, capturegroup can only be used in combination with 'captureto'", vHeader.Name)
+		}
+		if vHeader.CaptureTo != "" && (!vHeader.IsRegex() || vHeader.captureGroup() < 0) {
+			return nil, newConfigError(vHeader.Name, "captureto", "configuration incorrect for header %v, captureto requires 'regex' and a capture group which is not negative", vHeader.Name)
+		}
+		if vHeader.IsDistinctMatches() && !vHeader.IsContains() {
+			return nil, newConfigError(vHeader.Name, "distinctmatches", "configuration incorrect for header %v, distinctmatches can only be used in combination with 'contains'", vHeader.Name)
+		}
+		switch vHeader.FoldLocale {
+		case "", "ascii", "unicode", "tr", "az":
+		default:
+			return nil, newConfigError(vHeader.Name, "foldlocale", "configuration incorrect for header %v, unknown fold locale %v", vHeader.Name, vHeader.FoldLocale)
+		}
+		if vHeader.FoldLocale != "" && !vHeader.IsCaseInsensitive() {
+			return nil, newConfigError(vHeader.Name, "foldlocale", "configuration incorrect for header %v, foldlocale can only be used in combination with 'caseinsensitive'", vHeader.Name)
+		}
+		// regexes and globs are compiled with (?i), which always folds Unicode letters
+		if vHeader.FoldLocale != "" && (vHeader.IsRegex() || vHeader.IsGlob()) {
+			return nil, newConfigError(vHeader.Name, "foldlocale", "configuration incorrect for header %v, foldlocale can not be combined with 'regex' or 'glob'", vHeader.Name)
+		}
+		if vHeader.Default != "" && (vHeader.IsAbsent() || vHeader.IsPresent() || vHeader.IsStrict() || vHeader.IsAllowEmpty() || vHeader.EqualsHeader != "" || vHeader.BasicAuthField != "" || vHeader.HMAC != nil) {
+			return nil, newConfigError(vHeader.Name, "default", "configuration incorrect for header %v, default can not be combined with 'absent', 'present', 'strict', 'allowempty', 'equalsheader', 'basicauthfield' or 'hmac'", vHeader.Name)
+		}
+		if vHeader.IsRawName() && (!isHeaderSource(&vHeader) || strings.HasPrefix(vHeader.Name, ":") || strings.Contains(vHeader.Name, "*") || vHeader.EqualsHeader != "" || vHeader.BasicAuthField != "") {
+			return nil, newConfigError(vHeader.Name, "rawname", "configuration incorrect for header %v, rawname can only be used for headers without pattern and can not be combined with 'equalsheader' or 'basicauthfield'", vHeader.Name)
+		}
+		if vHeader.IsCaseInsensitiveName() && Source(vHeader.Source) != SourceQuery && Source(vHeader.Source) != SourceCookie {
+			return nil, newConfigError(vHeader.Name, "caseinsensitivename", "configuration incorrect for header %v, caseinsensitivename can only be used with the 'query' and 'cookie' source", vHeader.Name)
+		}
+		if vHeader.StripChars != "" && (vHeader.IsRegex() || vHeader.IsGlob() || vHeader.IsCIDR() || vHeader.IsNumeric() || vHeader.Hash != "" || vHeader.IsIPNormalize()) {
+			return nil, newConfigError(vHeader.Name, "stripchars", "configuration incorrect for header %v, stripchars can not be combined with 'regex', 'glob', 'cidr', 'numeric', 'hash' or 'ipnormalize'", vHeader.Name)
+		}
+		if vHeader.IsIPNormalize() {
+			if !vHeader.isExactMatch() {
+				return nil, newConfigError(vHeader.Name, "ipnormalize", "configuration incorrect for header %v, ipnormalize can only be used for exact matches", vHeader.Name)
+			}
+			for i, value := range vHeader.Values {
+				ip := net.ParseIP(value)
+				if ip == nil {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect for header %v, value %v is not an IP", vHeader.Name, value)
+				}
+				vHeader.Values[i] = ip.String()
+			}
+		}
+		if vHeader.MaxAge != "" {
+			var err error
+			vHeader.maxAge, err = time.ParseDuration(vHeader.MaxAge)
+			if err != nil || vHeader.maxAge <= 0 {
+				return nil, newConfigError(vHeader.Name, "maxage", "configuration incorrect for header %v, max age %v must be a positive duration", vHeader.Name, vHeader.MaxAge)
+			}
+			vHeader.clockSkew = 30 * time.Second
+			if vHeader.ClockSkew != "" {
+				vHeader.clockSkew, err = time.ParseDuration(vHeader.ClockSkew)
+				if err != nil || vHeader.clockSkew < 0 {
+					return nil, newConfigError(vHeader.Name, "clockskew", "configuration incorrect for header %v, clock skew %v must be a duration which is not negative", vHeader.Name, vHeader.ClockSkew)
+				}
+			}
+		} else if vHeader.TimeFormat != "" || vHeader.ClockSkew != "" {
+			return nil, newConfigError(vHeader.Name, "maxage", "configuration incorrect for header %v, timeformat and clockskew can only be used in combination with 'maxage'", vHeader.Name)
+		}
+		if vHeader.HMAC != nil {
+			if err := vHeader.HMAC.init(config.IsAllowUnsetEnv()); err != nil {
+				return nil, newConfigError(vHeader.Name, "hmac", "configuration incorrect for header %v, %w", vHeader.Name, err)
+			}
+			if len(vHeader.Values) > 0 || vHeader.ValuesURL != "" || len(vHeader.modeFlags()) > 0 || vHeader.IsAbsent() || vHeader.IsNegate() || vHeader.EqualsHeader != "" || vHeader.BasicAuthField != "" || vHeader.Hash != "" {
+				return nil, newConfigError(vHeader.Name, "hmac", "configuration incorrect for header %v, hmac can not be combined with values, a match mode, 'absent', 'negate', 'equalsheader', 'basicauthfield' or 'hash'", vHeader.Name)
+			}
+		}
+		if vHeader.ValuesURL != "" {
+			valuesURL, err := url.Parse(vHeader.ValuesURL)
+			if err != nil || (valuesURL.Scheme != "http" && valuesURL.Scheme != "https") {
+				return nil, newConfigError(vHeader.Name, "valuesurl", "configuration incorrect for header %v, values url must be an http or https url", vHeader.Name)
+			}
+			if !vHeader.isExactMatch() {
+				return nil, newConfigError(vHeader.Name, "valuesurl", "configuration incorrect for header %v, valuesurl can only be used for exact matches", vHeader.Name)
+			}
+			vHeader.refresh = 5 * time.Minute
+			if vHeader.ValuesRefresh != "" {
+				vHeader.refresh, err = time.ParseDuration(vHeader.ValuesRefresh)
+				if err != nil || vHeader.refresh <= 0 {
+					return nil, newConfigError(vHeader.Name, "valuesrefresh", "configuration incorrect for header %v, values refresh %v must be a positive duration", vHeader.Name, vHeader.ValuesRefresh)
+				}
+			}
+		} else if vHeader.ValuesRefresh != "" {
+			return nil, newConfigError(vHeader.Name, "valuesrefresh", "configuration incorrect for header %v, valuesrefresh can only be used in combination with 'valuesurl'", vHeader.Name)
+		}
+		if vHeader.IsRegexFullMatch() && !vHeader.IsRegex() {
+			return nil, newConfigError(vHeader.Name, "regexfullmatch", "configuration incorrect for header %v, regexfullmatch can only be used in combination with 'regex'", vHeader.Name)
+		}
+		if vHeader.IsRegex() || vHeader.IsGlob() {
+			vHeader.regexes = make([]*regexp.Regexp, 0, len(vHeader.Values))
+			for _, value := range vHeader.Values {
+				if vHeader.IsGlob() {
+					var err error
+					value, err = globToRegex(value)
+					if err != nil {
+						return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, invalid glob for header %v: %w", vHeader.Name, err)
+					}
+				}
+				// flags of the pattern itself like (?-i) still take precedence over the prepended flag
+				if vHeader.IsCaseInsensitive() {
+					value = "(?i)" + value
+				}
+				if vHeader.IsRegexFullMatch() {
+					value = `\A(?:` + value + `)\z`
+				}
+				re, err := regexp.Compile(value)
+				if err != nil {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, invalid regex %q for header %v: %w", value, vHeader.Name, err)
+				}
+				if vHeader.CaptureTo != "" && vHeader.captureGroup() > re.NumSubexp() {
+					return nil, newConfigError(vHeader.Name, "capturegroup", "configuration incorrect for header %v, regex %q has no capture group %d", vHeader.Name, value, vHeader.captureGroup())
+				}
+				vHeader.regexes = append(vHeader.regexes, re)
+			}
+		}
+		vHeader.logger = debugLogger
+		vHeader.failClosed = config.IsFailClosed()
+		vHeader.clientIP = newClientIPSource(config)
+		vHeader.now = now
+		if strings.Contains(vHeader.Name, "*") {
+			pattern, err := globToRegex(vHeader.Name)
+			if err != nil {
+				return nil, newConfigError(vHeader.Name, "name", "configuration incorrect, invalid header name pattern %v: %w", vHeader.Name, err)
+			}
+			// header names are case insensitive
+			vHeader.namePattern = regexp.MustCompile("(?i)" + pattern)
+		}
+		if len(vHeader.Methods) > 0 {
+			methods := make([]string, 0, len(vHeader.Methods))
+			for _, method := range vHeader.Methods {
+				method = strings.ToUpper(strings.TrimSpace(method))
+				if !isKnownMethod(method) {
+					return nil, newConfigError(vHeader.Name, "methods", "configuration incorrect for header %v, unknown method %v", vHeader.Name, method)
+				}
+				methods = append(methods, method)
+			}
+			vHeader.Methods = methods
+		}
+		if vHeader.PathRegex != "" {
+			re, err := regexp.Compile(vHeader.PathRegex)
+			if err != nil {
+				return nil, newConfigError(vHeader.Name, "pathregex", "configuration incorrect, invalid path regex %q for header %v: %w", vHeader.PathRegex, vHeader.Name, err)
+			}
+			vHeader.pathRegex = re
+		}
+		if vHeader.IsCIDR() {
+			vHeader.networks = make([]*net.IPNet, 0, len(vHeader.Values))
+			for _, value := range vHeader.Values {
+				_, network, err := net.ParseCIDR(strings.TrimSpace(value))
+				if err != nil {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, invalid cidr for header %v: %w", vHeader.Name, err)
+				}
+				vHeader.networks = append(vHeader.networks, network)
+			}
+		}
+		if vHeader.IsNumeric() {
+			vHeader.conditions = make([]numericCondition, 0, len(vHeader.Values))
+			for _, value := range vHeader.Values {
+				condition, err := parseNumericCondition(value)
+				if err != nil {
+					return nil, newConfigError(vHeader.Name, "values", "configuration incorrect, invalid numeric condition %q for header %v: %w", value, vHeader.Name, err)
+				}
+				vHeader.conditions = append(vHeader.conditions, condition)
+			}
+		}
+
+		// exact matches are looked up in a set instead of comparing every value, which keeps large lists fast
+		if len(vHeader.Values) > 0 && vHeader.isExactMatch() {
+			vHeader.valueSet = make(map[string]struct{}, len(vHeader.Values))
+			for _, value := range vHeader.Values {
+				vHeader.valueSet[foldCase(value, &vHeader)] = struct{}{}
+			}
+		}
+
+		headers = append(headers, vHeader)
+	}
+
+	// with 'and' logic a header can not be required to be absent and present at the same time
+	if !strings.EqualFold(config.Logic, string(LogicOr)) && !strings.EqualFold(config.Mode, string(ModeBlock)) {
+		absentRules := make(map[string]string, len(headers))
+		for _, vHeader := range headers {
+			if vHeader.IsAbsent() && !vHeader.IsNegate() {
+				absentRules[vHeader.scopeKey()] = vHeader.Name
+			}
+		}
+		for _, vHeader := range headers {
+			if vHeader.IsAbsent() || vHeader.IsNegate() || vHeader.allowsAbsent() {
+				continue
+			}
+			if name, ok := absentRules[vHeader.scopeKey()]; ok {
+				return nil, newConfigError(vHeader.Name, "absent", "configuration incorrect, header %v is required to be present and absent, previously as %v", vHeader.Name, name)
+			}
+		}
+	}
+
+	// lower priorities are evaluated first, the stable sort keeps the config order for equal priorities,
+	// deny rules are checked before the other rules of the same priority
+	slices.SortStableFunc(headers, func(a, b SingleHeader) int {
+		if a.Priority != b.Priority {
+			return a.Priority - b.Priority
+		}
+		if a.isDenyRule() != b.isDenyRule() {
+			if a.isDenyRule() {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	})
+
+	return headers, nil
+}
+
+func (a *HeaderMatch) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var failedHeader *SingleHeader
+	var oversized bool
+	var failedNames []string
+	var passedHeaders []*SingleHeader
+	var matchedNames []string
+	var captures http.Header
+	// a terminal rule or the exceeded evaluation budget end the evaluation before the groups are checked
+	var stopped bool
+	var applied bool
+	var satisfied []bool
+	if len(a.groups) > 0 {
+		satisfied = make([]bool, len(a.groups))
+	}
+
+	headers := a.selectHeaders(req)
+	// without a matching rule set or default headers there is nothing to allow the request
+	if len(headers) == 0 && len(a.ruleSets) > 0 {
+		a.rejectUnmatched(rw, req)
+		return
+	}
+
+	var start time.Time
+	if a.evalBudget > 0 {
+		start = a.now()
+	}
+
+	var correlationID string
+	if a.correlationHeader != "" {
+		correlationID = req.Header.Get(a.correlationHeader)
+	}
+
+	// oversized values are rejected before any matching, regardless of the logic and the order of the rules
+	if a.maxValueBytes > 0 {
+		if oversizedHeader := findOversized(req, headers, a.maxValueBytes); oversizedHeader != nil {
+			failedHeader = oversizedHeader
+			oversized = true
+			stopped = true
+			failedNames = []string{oversizedHeader.Name}
+		}
+	}
+
+	for i := range headers {
+		if oversized {
+			break
+		}
+		vHeader := &headers[i]
+
+		// the client is gone, so neither the next handler nor an error response would reach it
+		if req.Context().Err() != nil {
+			if vHeader.IsDebug() {
+				debugLog("Evaluation aborted", vHeader, "", slog.String("error", req.Context().Err().Error()))
+			}
+			return
+		}
+
+		// the remaining rules are not evaluated once the budget is used up
+		if a.evalBudget > 0 && a.now().Sub(start) > a.evalBudget {
+			loggerFor(vHeader).Warn("checkheaders: Evaluation budget exceeded",
+				slog.String("header", vHeader.Name),
+				slog.Duration("budget", a.evalBudget),
+				slog.Bool("failOpen", a.budgetFailOpen),
+			)
+			stopped = true
+			// with 'and' logic failing open keeps a rule which already failed, e.g. with reportall,
+			// with 'or' logic the failed rules don't decide the request as one of the remaining rules could pass
+			if !a.budgetFailOpen {
+				failedHeader = vHeader
+				failedNames = append(failedNames, vHeader.Name)
+			} else if a.logic == LogicOr {
+				failedHeader = nil
+				failedNames = nil
+			}
+			break
+		}
+
+		// rules which do not apply to the request are skipped, in 'and' logic this equals a passed rule
+		if !vHeader.appliesTo(req) {
+			continue
+		}
+		applied = true
+
+		// evaluations which are not sampled are checked by a copy of the rule without debug output,
+		// sampled ones by a copy tagged with the correlation ID of the request
+		if vHeader.IsDebug() && !vHeader.debugSampled() {
+			quiet := *vHeader
+			quiet.Debug = nil
+			vHeader = &quiet
+		} else if vHeader.IsDebug() && correlationID != "" {
+			traced := *vHeader
+			traced.correlationID = correlationID
+			vHeader = &traced
+		}
+
+		var captured string
+		headerResult := checkHeader(req, vHeader, &captured)
+		if vHeader.IsNegate() {
+			headerResult = headerResult.negate()
+		}
+		if a.mode == ModeBlock {
+			headerResult = headerResult.block()
+		}
+
+		// rules which could not be evaluated reject the request, even if other rules pass
+		if headerResult == resultError {
+			failedHeader = vHeader
+			failedNames = append(failedNames, vHeader.Name)
+			break
+		}
+
+		// a rule of a group does not reject the request on its own, the group is checked once all rules are evaluated
+		if vHeader.group != nil {
+			if headerResult != resultMatched {
+				continue
+			}
+			satisfied[vHeader.group.index] = true
+		}
+
+		if headerResult.passed() {
+			if a.auditLog && headerResult == resultMatched {
+				matchedNames = append(matchedNames, vHeader.Name)
+			}
+			if len(vHeader.OnPassSetHeader) > 0 {
+				passedHeaders = append(passedHeaders, vHeader)
+			}
+			if vHeader.CaptureTo != "" && captured != "" {
+				if captures == nil {
+					captures = http.Header{}
+				}
+				captures.Set(vHeader.CaptureTo, captured)
+			}
+			if a.logic == LogicOr {
+				failedHeader = nil
+				break
+			}
+			// a matching terminal rule allows the request without checking the remaining rules,
+			// an allowed absent header does not, so optional terminal rules can be combined with other rules
+			if vHeader.IsTerminal() && headerResult == resultMatched {
+				stopped = true
+				break
+			}
+			continue
+		}
+
+		// remember the first failing header, it determines the rejection response
+		if failedHeader == nil {
+			failedHeader = vHeader
+		}
+		if a.reportAll {
+			failedNames = append(failedNames, vHeader.Name)
+		}
+		// a failing terminal rule rejects the request, even if a remaining rule would pass with 'or' logic
+		if (!a.reportAll && a.logic == LogicAnd) || vHeader.IsTerminal() {
+			break
+		}
+	}
+
+	// in 'or' logic one rule has to pass, skipped rules don't allow the request
+	if a.logic == LogicOr && !applied && !stopped && len(headers) > 0 {
+		a.rejectUnmatched(rw, req)
+		return
+	}
+
+	if !stopped && (failedHeader == nil || a.reportAll) {
+		for _, group := range a.groups {
+			if satisfied[group.index] {
+				continue
+			}
+			if failedHeader == nil {
+				failedHeader = &group.rule
+			}
+			if !a.reportAll {
+				break
+			}
+			failedNames = append(failedNames, group.rule.Name)
+		}
+	}
+
+	if failedHeader == nil {
+		a.counters.allowed.Add(1)
+		if a.last != nil {
+			a.last.store(true, "")
+		}
+		for i := range headers {
+			if headers[i].IsRemoveOnPass() && isHeaderSource(&headers[i]) {
+				removeHeader(req, &headers[i])
+			}
+		}
+		// headers are only added once the whole request is allowed, they never reach the rejection path
+		for _, passedHeader := range passedHeaders {
+			for name, value := range passedHeader.OnPassSetHeader {
+				req.Header.Set(name, value)
+			}
+		}
+		for name := range captures {
+			req.Header.Set(name, captures.Get(name))
+		}
+		if a.auditLog {
+			a.audit(req, auditAllowed, nil, matchedNames)
+		}
+		a.next.ServeHTTP(rw, req)
+	} else {
+		a.counters.blocked.Add(1)
+		a.counters.blockedByHeader.Add(failedHeader.Name, 1)
+		if a.last != nil {
+			a.last.store(false, failedHeader.Name)
+		}
+		if a.dryRun {
+			// the request is counted and logged as blocked but forwarded unchanged
+			dryRunLog(req, failedHeader)
+			if a.auditLog {
+				a.audit(req, auditDryRun, failedHeader, matchedNames)
+			}
+			a.next.ServeHTTP(rw, req)
+			return
+		}
+		if !a.isEnforced(req) {
+			notEnforcedLog(req, failedHeader)
+			if a.auditLog {
+				a.audit(req, auditNotEnforced, failedHeader, matchedNames)
+			}
+			a.next.ServeHTTP(rw, req)
+			return
+		}
+		if a.auditLog {
+			a.audit(req, auditBlocked, failedHeader, matchedNames)
+		}
+		if a.reportAll {
+			rw.Header().Set("X-Checkheaders-Failed", strings.Join(failedNames, ", "))
+		}
+		a.reject(rw, req, failedHeader, oversized)
+	}
+}
+
+// outcomes of a request in the audit log
+const (
+	auditAllowed     = "allowed"
+	auditBlocked     = "blocked"
+	auditDryRun      = "dryrun"
+	auditNotEnforced = "notenforced"
+)
+
+// auditForwarded returns the outcome of a blocked request which is forwarded by dryrun or enforcepercent
+func auditForwarded(dryRun bool) string {
+	if dryRun {
+		return auditDryRun
+	}
+
+	return auditNotEnforced
+}
+
+// audit logs the outcome of the request with the header which caused a block and the headers which matched,
+// the failed header is nil if no rule set matched or no rule applied with 'or' logic
+func (a *HeaderMatch) audit(req *http.Request, outcome string, failedHeader *SingleHeader, matchedNames []string) {
+	l := logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	var failedName string
+	if failedHeader != nil {
+		failedName = failedHeader.Name
+	}
+	l.Info("checkheaders (audit): Request evaluated",
+		slog.String("middleware", a.name),
+		slog.String("outcome", outcome),
+		slog.String("failedHeader", failedName),
+		slog.Any("matchedHeaders", matchedNames),
+		slog.String("clientIP", clientIP(req, a.clientIP)),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+	)
+}
+
+// rejectUnmatched rejects a request without a failed header, as no rule set matched the request
+// or no rule applied to it with 'or' logic
+func (a *HeaderMatch) rejectUnmatched(rw http.ResponseWriter, req *http.Request) {
+	a.counters.blocked.Add(1)
+	if a.last != nil {
+		a.last.store(false, "")
+	}
+	if a.dryRun || !a.isEnforced(req) {
+		if a.auditLog {
+			a.audit(req, auditForwarded(a.dryRun), nil, nil)
+		}
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+	if a.auditLog {
+		a.audit(req, auditBlocked, nil, nil)
+	}
+	a.reject(rw, req, nil, false)
+}
+
+// LastResult returns whether the last evaluated request was allowed and the name of the header which caused a block,
+// which is empty if no rule set matched or no rule applied with 'or' logic. Requests forwarded by dryrun or enforcepercent are reported as blocked.
+// It requires tracklastresult, otherwise false and an empty name are returned.
+func (a *HeaderMatch) LastResult() (bool, string) {
+	if a.last == nil {
+		return false, ""
+	}
+
+	a.last.mu.Lock()
+	defer a.last.mu.Unlock()
+
+	return a.last.allowed, a.last.header
+}
+
+// Stats returns a snapshot of the allowed and blocked request counters
+func (a *HeaderMatch) Stats() Stats {
+	stats := Stats{
+		Allowed:         a.counters.allowed.Value(),
+		Blocked:         a.counters.blocked.Value(),
+		BlockedByHeader: map[string]int64{},
+	}
+
+	a.counters.blockedByHeader.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			stats.BlockedByHeader[kv.Key] = v.Value()
+		}
+	})
+
+	return stats
+}
+
+// appliesTo checks whether the header rule should be evaluated for the request
+func (s *SingleHeader) appliesTo(req *http.Request) bool {
+	if s.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, s.PathPrefix) {
+		return false
+	}
+	if s.pathRegex != nil && !s.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(s.Methods) > 0 && !slices.Contains(s.Methods, req.Method) {
+		return false
+	}
+
+	return true
+}
+
+// checkHeader checks the request header against the configured header rule
+// when all values should be checked or the name is a pattern matching several headers, every value is validated on its own;
+// MatchOne passes if one value is valid, MatchAll and MatchNone require every value to be valid
+// captured is set to the capture group of the first matching regex if the header rule has a capture header
+func checkHeader(req *http.Request, vHeader *SingleHeader, captured *string) result {
+	var reqHeaderVals []string
+	if vHeader.template != nil {
+		value, err := executeTemplate(req, vHeader)
+		if err != nil {
+			// like undecodable strict values, a failing template can not be inverted into a pass by negate
+			headerResult := resultFailed
+			if vHeader.failClosed {
+				headerResult = resultError
+			}
+			if vHeader.IsDebug() {
+				debugLog("Header validated", vHeader, "", slog.String("mode", "template"), slog.String("error", err.Error()), slog.Bool("result", false), slog.String("outcome", headerResult.String()))
+			}
+			return headerResult
+		}
+		if value != "" {
+			reqHeaderVals = []string{value}
+		}
+	} else {
+		reqHeaderVals = requestValues(req, vHeader)
+	}
+
+	if vHeader.IsAbsent() {
+		return checkAbsent(reqHeaderVals, vHeader)
+	}
+
+	if vHeader.EqualsHeader != "" {
+		return checkEqualsHeader(req, vHeader)
+	}
+	if vHeader.BasicAuthField != "" {
+		return checkBasicAuth(req, reqHeaderVals, vHeader, captured)
+	}
+	if vHeader.HMAC != nil {
+		return checkHMAC(req, reqHeaderVals, vHeader)
+	}
+	if len(reqHeaderVals) == 0 && vHeader.Default != "" {
+		// an absent header is checked like an empty value, which is replaced by the default in checkValue
+		reqHeaderVals = []string{""}
+	}
+	if len(reqHeaderVals) == 0 {
+		headerResult := checkMissing(vHeader)
+		if vHeader.IsDebug() {
+			debugLog("Header validated", vHeader, "", slog.String("mode", vHeader.matchMode()), slog.Bool("result", headerResult.passed()), slog.String("outcome", headerResult.String()))
+		}
+		return headerResult
+	}
+
+	if vHeader.IsSplitCommas() {
+		// the tokens are validated like separate occurrences of the header
+		if !vHeader.IsAllValues() && vHeader.namePattern == nil {
+			reqHeaderVals = reqHeaderVals[:1]
+		}
+		reqHeaderVals = splitCommas(reqHeaderVals)
+		if vHeader.MatchType == string(MatchAll) && vHeader.MinMatches == nil {
+			return checkTokensMatchAll(reqHeaderVals, vHeader, captured)
+		}
+	} else if !vHeader.IsAllValues() && vHeader.namePattern == nil {
+		return checkValue(reqHeaderVals[0], vHeader, captured)
+	}
+
+	validCount := 0
+	for _, reqHeaderVal := range reqHeaderVals {
+		valueResult := checkValue(reqHeaderVal, vHeader, captured)
+		if valueResult == resultError {
+			return valueResult
+		}
+		if valueResult.passed() {
+			validCount++
+		}
+	}
+
+	if vHeader.MatchType == string(MatchOne) {
+		return resultOf(validCount > 0)
+	}
+
+	return resultOf(validCount == len(reqHeaderVals))
+}
+
+// checkTokensMatchAll checks whether every configured value is matched by at least one of the split tokens,
+// as a single token of a list like 'no-store, no-cache' can not match all configured values on its own
+func checkTokensMatchAll(tokens []string, vHeader *SingleHeader, captured *string) result {
+	for i := range vHeader.Values {
+		single := vHeader.singleValue(i)
+		matched := false
+		for _, token := range tokens {
+			valueResult := checkValue(token, single, captured)
+			if valueResult == resultError {
+				return valueResult
+			}
+			if valueResult.passed() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return resultFailed
+		}
+	}
+
+	return resultMatched
+}
+
+// singleValue returns a copy of the header rule which only matches the configured value at the index
+func (s *SingleHeader) singleValue(i int) *SingleHeader {
+	single := *s
+	single.Values = s.Values[i : i+1]
+	single.MatchType = string(MatchOne)
+	if len(s.regexes) > 0 {
+		single.regexes = s.regexes[i : i+1]
+	}
+	if len(s.networks) > 0 {
+		single.networks = s.networks[i : i+1]
+	}
+	if len(s.conditions) > 0 {
+		single.conditions = s.conditions[i : i+1]
+	}
+
+	return &single
+}
+
+// stripChars removes all characters contained in chars from the value
+func stripChars(value string, chars string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(chars, r) {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// splitCommas splits comma separated list values into their trimmed tokens, empty tokens are skipped.
+// If no token remains a single empty value is returned, which is handled like an empty header.
+func splitCommas(values []string) []string {
+	var tokens []string
+	for _, value := range values {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return []string{""}
+	}
+
+	return tokens
+}
+
+// isHeaderSource checks whether the value of the header rule is read from the request headers
+func isHeaderSource(vHeader *SingleHeader) bool {
+	return vHeader.Source == "" || Source(vHeader.Source) == SourceHeader
+}
+
+// removeHeader removes the header of the rule from the request, including all headers matching a name pattern
+func removeHeader(req *http.Request, vHeader *SingleHeader) {
+	if vHeader.IsRawName() {
+		delete(req.Header, vHeader.Name)
+		return
+	}
+	if vHeader.namePattern == nil {
+		req.Header.Del(vHeader.Name)
+		return
+	}
+
+	for name := range req.Header {
+		if vHeader.namePattern.MatchString(name) {
+			delete(req.Header, name)
+		}
+	}
+}
+
+// requestValues returns all values of the request for the configured source of the header rule
+func requestValues(req *http.Request, vHeader *SingleHeader) []string {
+	if vHeader.template != nil {
+		// failing templates are handled by checkHeader
+		value, err := executeTemplate(req, vHeader)
+		if err != nil || value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+
+	switch Source(vHeader.Source) {
+	case SourceQuery:
+		if !vHeader.IsCaseInsensitiveName() {
+			return req.URL.Query()[vHeader.Name]
+		}
+		var values []string
+		for name, queryValues := range req.URL.Query() {
+			if strings.EqualFold(name, vHeader.Name) {
+				values = append(values, queryValues...)
+			}
+		}
+		return values
+	case SourceCookie:
+		// an empty cookie is handled the same way as an empty header
+		var values []string
+		for _, cookie := range req.Cookies() {
+			if cookie.Name == vHeader.Name || (vHeader.IsCaseInsensitiveName() && strings.EqualFold(cookie.Name, vHeader.Name)) {
+				values = append(values, cookie.Value)
+			}
+		}
+		return values
+	case SourcePath:
+		// the escaped path keeps encoded characters like %2f, which can be decoded with urldecode
+		if path := req.URL.EscapedPath(); path != "" {
+			return []string{path}
+		}
+		return nil
+	case SourceRawQuery:
+		if req.URL.RawQuery != "" {
+			return []string{req.URL.RawQuery}
+		}
+		return nil
+	case SourceForm:
+		return formValues(req, vHeader.Name)
+	default:
+		switch vHeader.Name {
+		case PseudoHeaderHost:
+			return []string{req.Host}
+		case PseudoHeaderMethod:
+			return []string{req.Method}
+		case PseudoHeaderTLSCN, PseudoHeaderTLSSAN:
+			return clientCertificateValues(req, vHeader.Name)
+		case PseudoHeaderClientIP:
+			if ip := clientIP(req, vHeader.clientIP); ip != "" {
+				return []string{ip}
+			}
+			return nil
+		}
+
+		if vHeader.IsRawName() {
+			return req.Header[vHeader.Name]
+		}
+		if vHeader.namePattern == nil {
+			return req.Header.Values(vHeader.Name)
+		}
+
+		var values []string
+		for name, headerValues := range req.Header {
+			if !vHeader.namePattern.MatchString(name) || len(headerValues) == 0 {
+				continue
+			}
+			if vHeader.IsAllValues() {
+				values = append(values, headerValues...)
+			} else {
+				values = append(values, headerValues[0])
+			}
+		}
+		return values
+	}
+}
+
+// checkBasicAuth checks the configured field of the basic auth credentials against the header rule
+// an Authorization header which does not contain basic credentials fails the rule
+func checkBasicAuth(req *http.Request, reqHeaderVals []string, vHeader *SingleHeader, captured *string) result {
+	if len(reqHeaderVals) == 0 {
+		return checkMissing(vHeader)
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		if vHeader.IsDebug() {
+			debugLog("Header validated", vHeader, "", slog.String("mode", "basicauth"), slog.String("error", "no basic auth credentials"), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+		}
+		return resultFailed
+	}
+
+	if vHeader.BasicAuthField == "password" {
+		return checkValue(password, vHeader, captured)
+	}
+
+	return checkValue(username, vHeader, captured)
+}
+
+// checkHMAC verifies the first header value as the HMAC signature of the request body
+func checkHMAC(req *http.Request, reqHeaderVals []string, vHeader *SingleHeader) result {
+	if len(reqHeaderVals) == 0 {
+		return checkMissing(vHeader)
+	}
+
+	reqHeaderVal := strings.TrimSpace(reqHeaderVals[0])
+	signature, err := vHeader.HMAC.decode(reqHeaderVal)
+	if err != nil {
+		if vHeader.IsDebug() {
+			debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "hmac"), slog.String("error", err.Error()), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+		}
+		return resultFailed
+	}
+	body, ok := bufferBody(req)
+	if !ok {
+		if vHeader.IsDebug() {
+			debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "hmac"), slog.String("error", "request body can not be read or is too large"), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+		}
+		return resultFailed
+	}
+
+	mac := hmac.New(vHeader.HMAC.hash, vHeader.HMAC.secret)
+	mac.Write(body)
+	headerResult := resultOf(hmac.Equal(signature, mac.Sum(nil)))
+	if vHeader.IsDebug() {
+		debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "hmac"), slog.Bool("result", headerResult.passed()), slog.String("outcome", headerResult.String()))
+	}
+
+	return headerResult
+}
+
+// init validates the hmac configuration and prepares the secret and hash function
+func (h *HMACConfig) init(allowUnset bool) error {
+	secret, err := expandEnv([]string{h.Secret}, allowUnset)
+	if err != nil {
+		return err
+	}
+	if secret[0] == "" {
+		return errors.New("hmac secret must not be empty")
+	}
+	h.secret = []byte(secret[0])
+
+	switch strings.ToLower(h.Algorithm) {
+	case "", "sha256":
+		h.hash = sha256.New
+	case "sha1":
+		h.hash = sha1.New
+	case "sha512":
+		h.hash = sha512.New
+	default:
+		return fmt.Errorf("unknown hmac algorithm %v", h.Algorithm)
+	}
+	if h.Content != "" && h.Content != "body" {
+		return fmt.Errorf("unknown hmac content %v", h.Content)
+	}
+	if h.Encoding != "" && h.Encoding != "hex" && h.Encoding != "base64" {
+		return fmt.Errorf("unknown hmac encoding %v", h.Encoding)
+	}
+
+	return nil
+}
+
+// decode removes the prefix from the signature and decodes it with the configured encoding
+func (h *HMACConfig) decode(value string) ([]byte, error) {
+	signature, ok := strings.CutPrefix(value, h.Prefix)
+	if !ok {
+		return nil, fmt.Errorf("signature does not start with %v", h.Prefix)
+	}
+	if h.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+
+	return hex.DecodeString(signature)
+}
+
+// decodeError reports a value of a strict header rule which could not be decoded
+func decodeError(reqHeaderVal string, vHeader *SingleHeader, mode string, err error) result {
+	if vHeader.IsDebug() {
+		debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", mode), slog.String("error", err.Error()), slog.Bool("result", false), slog.String("outcome", resultError.String()))
+	}
+
+	return resultError
+}
+
+// maxBodyBytes limits the size of a request body read for the form source and hmac rules
+const maxBodyBytes = 1 << 20
+
+// formValues returns the values of the field of an application/x-www-form-urlencoded request body.
+// The body is buffered and restored, so it can be read again by the next rule and the next handler.
+// Bodies larger than maxBodyBytes are not parsed and the field is handled as absent.
+func formValues(req *http.Request, name string) []string {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+
+	buf, ok := bufferBody(req)
+	if !ok {
+		return nil
+	}
+
+	form, err := url.ParseQuery(string(buf))
+	if err != nil {
+		return nil
+	}
+
+	return form[name]
+}
+
+// bufferBody reads the request body and restores it, so it can be read again by the next rule and the next handler.
+// It returns false if the body is larger than maxBodyBytes or can not be read.
+func bufferBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+
+	body := req.Body
+	buf, err := io.ReadAll(io.LimitReader(body, maxBodyBytes+1))
+	if len(buf) > maxBodyBytes || err != nil {
+		// the already read part is put back in front of the unread rest of the body
+		req.Body = bufferedBody{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+		return nil, false
+	}
+	req.Body = bufferedBody{Reader: bytes.NewReader(buf), Closer: body}
+
+	return buf, true
+}
+
+// bufferedBody is a request body whose content is read from a buffer, closing it closes the original body
+type bufferedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// newClientIPSource returns where the client IP is read from according to the configuration
+func newClientIPSource(config *Config) clientIPSource {
+	source := clientIPSource{header: config.ClientIPHeader, trustedProxies: config.TrustedProxyCount}
+	if source.header == "" {
+		source.header = "X-Forwarded-For"
+	}
+
+	return source
+}
+
+// clientIP returns the client IP of the request. Without trusted proxies it is the first entry of the client IP header,
+// otherwise the entry added by the farthest trusted proxy, as every proxy appends the address it received the request from.
+// Requests without the header are treated as direct connections and the remote address is used,
+// an empty string is returned if the header has fewer entries than trusted proxies.
+func clientIP(req *http.Request, source clientIPSource) string {
+	var entries []string
+	for _, value := range req.Header.Values(source.header) {
+		for _, entry := range strings.Split(value, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+
+	if len(entries) == 0 {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	}
+	if source.trustedProxies == 0 {
+		return entries[0]
+	}
+	if source.trustedProxies > len(entries) {
+		return ""
+	}
+
+	return entries[len(entries)-source.trustedProxies]
+}
+
+// splitValue splits the request value on the configured separator and returns the trimmed element at the split index.
+// A negative index counts from the end, an index out of range returns false.
+func splitValue(reqHeaderVal string, vHeader *SingleHeader) (string, bool) {
+	elements := strings.Split(reqHeaderVal, vHeader.SplitBy)
+
+	index := 0
+	if vHeader.SplitIndex != nil {
+		index = *vHeader.SplitIndex
+	}
+	if index < 0 {
+		index += len(elements)
+	}
+	if index < 0 || index >= len(elements) {
+		return "", false
+	}
+
+	return strings.TrimSpace(elements[index]), true
+}
+
+// clientCertificateValues returns the common name or the subject alternative names of the TLS client certificate,
+// requests without a client certificate have no values
+func clientCertificateValues(req *http.Request, name string) []string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+	if name == PseudoHeaderTLSCN {
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	}
+
+	values := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	values = append(values, cert.DNSNames...)
+	values = append(values, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		values = append(values, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		values = append(values, uri.String())
+	}
+
+	return values
+}
+
+// startRemoteValues fetches the values of all header rules with a values URL
+// and refreshes them in the background until the context is done
+func (a *HeaderMatch) startRemoteValues(ctx context.Context) error {
+	ruleSets := [][]SingleHeader{a.headers}
+	for _, ruleSet := range a.ruleSets {
+		ruleSets = append(ruleSets, ruleSet)
+	}
+
+	for _, headers := range ruleSets {
+		for i := range headers {
+			vHeader := &headers[i]
+			if vHeader.ValuesURL == "" {
+				continue
+			}
+
+			set, err := fetchValues(ctx, vHeader)
+			if err != nil {
+				return newConfigError(vHeader.Name, "valuesurl", "configuration incorrect for header %v, can not fetch values: %w", vHeader.Name, err)
+			}
+			vHeader.remote = &remoteValues{set: set}
+			go refreshValues(ctx, vHeader)
+		}
+	}
+
+	return nil
+}
+
+// refreshValues periodically fetches the values of the header rule, the last fetched values are kept on errors
+func refreshValues(ctx context.Context, vHeader *SingleHeader) {
+	ticker := time.NewTicker(vHeader.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			set, err := fetchValues(ctx, vHeader)
+			if err != nil {
+				loggerFor(vHeader).Warn("checkheaders: Refreshing values failed, keeping the last values",
+					slog.String("header", vHeader.Name),
+					slog.String("url", vHeader.ValuesURL),
+					slog.String("error", err.Error()),
+				)
+				continue
+			}
+			vHeader.remote.store(set)
+		}
+	}
+}
+
+// maxValuesBytes limits the size of a fetched values list
+const maxValuesBytes = 10 << 20
+
+// valuesClient fetches the values lists, the timeout keeps a hanging server from blocking New and the refresh forever
+var valuesClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchValues fetches the values list of the header rule and merges it with the configured values
+func fetchValues(ctx context.Context, vHeader *SingleHeader) (map[string]struct{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, vHeader.ValuesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := valuesClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	values, err := parseValues(io.LimitReader(resp.Body, maxValuesBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{}, len(values)+len(vHeader.Values))
+	for _, value := range vHeader.Values {
+		set[foldCase(value, vHeader)] = struct{}{}
+	}
+	for _, value := range values {
+		if vHeader.IsNormalizeUnicode() {
+			value = normalizeUnicode(value)
+		}
+		if vHeader.StripChars != "" {
+			value = stripChars(value, vHeader.StripChars)
+		}
+		// fetched values which are not an IP can never match and are kept as they are
+		if ip := net.ParseIP(value); ip != nil && vHeader.IsIPNormalize() {
+			value = ip.String()
+		}
+		set[foldCase(value, vHeader)] = struct{}{}
+	}
+
+	return set, nil
+}
+
+// readValuesFile reads one value per line from the file
+func readValuesFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseValues(file)
+}
+
+// parseValues reads one value per line, empty lines and lines starting with '#' are skipped
+func parseValues(r io.Reader) ([]string, error) {
+	var values []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// executeTemplate renders the source template of the header rule with the request as data
+func executeTemplate(req *http.Request, vHeader *SingleHeader) (string, error) {
+	var buf bytes.Buffer
+	if err := vHeader.template.Execute(&buf, req); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// checkValue checks a single request header value against the configured header rule.
+// The value is selected, decoded, trimmed and normalized in this order before it is checked, as documented in the README.
+func checkValue(reqHeaderVal string, vHeader *SingleHeader, captured *string) result {
+	var headerResult result
+
+	if vHeader.SplitBy != "" && reqHeaderVal != "" {
+		element, ok := splitValue(reqHeaderVal, vHeader)
+		if !ok {
+			if vHeader.IsDebug() {
+				debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "split"), slog.String("error", "split index out of range"), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+			}
+			return resultFailed
+		}
+		reqHeaderVal = element
+	}
+
+	if vHeader.Parameter != nil && reqHeaderVal != "" {
+		mediaType, params, err := mime.ParseMediaType(reqHeaderVal)
+		if err != nil {
+			if vHeader.IsDebug() {
+				debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "parameter"), slog.String("error", err.Error()), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+			}
+			return resultFailed
+		}
+		// a missing parameter is handled like an empty value
+		if *vHeader.Parameter == "" {
+			reqHeaderVal = mediaType
+		} else {
+			reqHeaderVal = params[strings.ToLower(*vHeader.Parameter)]
+		}
+	}
+
+	// only the scheme before the first space is checked, e.g. 'Bearer' of 'Bearer <token>'
+	if vHeader.IsSchemePrefix() {
+		reqHeaderVal, _, _ = strings.Cut(strings.TrimSpace(reqHeaderVal), " ")
+	}
+
+	if vHeader.JWTClaim != "" && reqHeaderVal != "" {
+		claim, err := jwtClaim(reqHeaderVal, vHeader.JWTClaim)
+		if err != nil {
+			if vHeader.IsDebug() {
+				debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "jwtclaim"), slog.String("error", err.Error()), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+			}
+			return resultFailed
+		}
+		reqHeaderVal = claim
+	}
+
+	if vHeader.IsURLDecode() {
+		unescape := url.QueryUnescape
+		// a '+' in a path is a literal plus, not an encoded space
+		if Source(vHeader.Source) == SourcePath {
+			unescape = url.PathUnescape
+		}
+		decoded, err := unescape(reqHeaderVal)
+		if err != nil && vHeader.failClosed && vHeader.IsStrict() {
+			return decodeError(reqHeaderVal, vHeader, "urldecode", err)
+		}
+		reqHeaderVal = decoded
+	}
+
+	if vHeader.IsBase64Decode() {
+		decoded, err := base64.StdEncoding.DecodeString(reqHeaderVal)
+		if err == nil {
+			reqHeaderVal = string(decoded)
+		} else if vHeader.failClosed && vHeader.IsStrict() {
+			return decodeError(reqHeaderVal, vHeader, "base64decode", err)
+		} else if vHeader.IsDebug() {
+			debugLog("Base64 decoding failed, using raw value", vHeader, reqHeaderVal, slog.String("error", err.Error()))
+		}
+	}
+
+	if vHeader.IsTrimSpace() {
+		reqHeaderVal = strings.TrimSpace(reqHeaderVal)
+	}
+
+	if reqHeaderVal == "" {
+		reqHeaderVal = vHeader.Default
+	}
+
+	if vHeader.IsNormalizeUnicode() {
+		reqHeaderVal = normalizeUnicode(reqHeaderVal)
+	}
+
+	if vHeader.StripChars != "" {
+		reqHeaderVal = stripChars(reqHeaderVal, vHeader.StripChars)
+	}
+
+	if vHeader.IsIPNormalize() && reqHeaderVal != "" {
+		ip := net.ParseIP(reqHeaderVal)
+		if ip == nil {
+			if vHeader.IsDebug() {
+				debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "ipnormalize"), slog.String("error", "not an IP"), slog.Bool("result", false), slog.String("outcome", resultFailed.String()))
+			}
+			return resultFailed
+		}
+		reqHeaderVal = ip.String()
+	}
+
+	if reqHeaderVal == "" {
+		headerResult = checkRequired(&reqHeaderVal, vHeader)
+	} else if !checkLength(&reqHeaderVal, vHeader) || !checkRange(&reqHeaderVal, vHeader) || !checkMaxAge(&reqHeaderVal, vHeader) {
+		headerResult = resultFailed
+	} else if len(vHeader.Values) == 0 && vHeader.remote == nil {
+		// rules without values are only constrained by the length and range bounds
+		headerResult = resultMatched
+	} else if vHeader.IsContains() {
+		headerResult = resultOf(checkContains(&reqHeaderVal, vHeader))
+	} else if vHeader.IsPrefix() {
+		headerResult = resultOf(checkPrefix(&reqHeaderVal, vHeader))
+	} else if vHeader.IsSuffix() {
+		headerResult = resultOf(checkSuffix(&reqHeaderVal, vHeader))
+	} else if vHeader.IsRegex() {
+		headerResult = resultOf(checkRegex(&reqHeaderVal, vHeader, captured))
+	} else if vHeader.IsGlob() {
+		headerResult = resultOf(checkGlob(&reqHeaderVal, vHeader))
+	} else if vHeader.IsCIDR() {
+		headerResult = resultOf(checkCIDR(&reqHeaderVal, vHeader))
+	} else if vHeader.IsNumeric() {
+		headerResult = resultOf(checkNumeric(&reqHeaderVal, vHeader))
+	} else {
+		headerResult = checkRequired(&reqHeaderVal, vHeader)
+	}
+
+	if vHeader.IsDebug() {
+		debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", vHeader.matchMode()), slog.Bool("result", headerResult.passed()), slog.String("outcome", headerResult.String()))
+	}
+
+	return headerResult
+}
+
+// isEnforced checks whether the request falls into the enforced percentage of requests.
+// The bucket is derived from a hash of the enforce hash header, or the client IP if the header is unset or empty,
+// so the same client is consistently enforced or not.
+func (a *HeaderMatch) isEnforced(req *http.Request) bool {
+	if a.enforcePercent >= 100 {
+		return true
+	}
+
+	key := ""
+	if a.enforceHeader != "" {
+		key = req.Header.Get(a.enforceHeader)
+	}
+	if key == "" {
+		key = clientIP(req, a.clientIP)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32()%100 < uint32(a.enforcePercent)
+}
+
+// reject writes the rejection response, using the status code of the failed header if configured
+// or redirects the request when a redirect url is configured
+func (a *HeaderMatch) reject(rw http.ResponseWriter, req *http.Request, failedHeader *SingleHeader, oversized bool) {
+	// the headers set by the plugin itself take precedence over the configured ones,
+	// the response headers of the failed rule over the global reject headers
+	for name, values := range a.rejectHeaders {
+		rw.Header()[name] = slices.Clone(values)
+	}
+	if failedHeader != nil {
+		for name, value := range failedHeader.ResponseHeaders {
+			rw.Header().Set(name, value)
+		}
+	}
+	if failedHeader != nil && failedHeader.RejectReason != "" {
+		rw.Header().Set("X-Checkheaders-Reason", failedHeader.RejectReason)
+	}
+
+	if a.redirectURL != "" {
+		http.Redirect(rw, req, a.redirectURL, a.redirectStatus)
+		return
+	}
+
+	statusCode := a.rejectStatusCode
+	if oversized {
+		statusCode = a.oversizeStatus
+	} else if failedHeader != nil && failedHeader.StatusCode != nil {
+		statusCode = *failedHeader.StatusCode
+	} else if failedHeader != nil && isMissing(req, failedHeader) {
+		statusCode = a.missingStatusCode
+	}
+
+	// the challenge is only meaningful for unauthorized responses
+	if statusCode == http.StatusUnauthorized && a.wwwAuthenticate != "" {
+		rw.Header().Set("WWW-Authenticate", a.wwwAuthenticate)
+	}
+	rw.Header().Set("Content-Type", a.rejectContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(statusCode)
+	fmt.Fprintln(rw, a.rejectMessage)
+}
+
+// findOversized returns the first header rule which applies to the request and reads a value longer than the limit
+func findOversized(req *http.Request, headers []SingleHeader, maxValueBytes int) *SingleHeader {
+	for i := range headers {
+		if headers[i].appliesTo(req) && exceedsMaxValueBytes(req, &headers[i], maxValueBytes) {
+			return &headers[i]
+		}
+	}
+
+	return nil
+}
+
+// exceedsMaxValueBytes checks whether one of the request values read by the header rule is longer than the limit
+func exceedsMaxValueBytes(req *http.Request, vHeader *SingleHeader, maxValueBytes int) bool {
+	for _, value := range requestValues(req, vHeader) {
+		if len(value) > maxValueBytes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMissing checks whether the header rule failed because a required header is absent or empty
+func isMissing(req *http.Request, vHeader *SingleHeader) bool {
+	// a header with a default never counts as absent, the default failed the rule
+	if vHeader.IsNegate() || vHeader.IsAbsent() || vHeader.Default != "" {
+		return false
+	}
+
+	reqHeaderVals := requestValues(req, vHeader)
+	if len(reqHeaderVals) == 0 {
+		return !vHeader.allowsAbsent()
+	}
+	if vHeader.allowsEmpty() {
+		return false
+	}
+
+	for _, value := range reqHeaderVals {
+		if value != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isMatchType checks whether the match type is one of the MatchType constants
+func isMatchType(matchType string) bool {
+	switch MatchType(matchType) {
+	case MatchAll, MatchOne, MatchNone:
+		return true
+	}
+
+	return false
+}
+
+// isKnownMethod checks whether the method is one of the standard HTTP methods
+func isKnownMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRejectStatusCode checks whether the status code can be used to reject a request
+func isRejectStatusCode(statusCode int) bool {
+	return statusCode >= 400 && statusCode <= 599
+}
+
+// checkAbsent checks whether a header is absent or only present with empty values
+func checkAbsent(reqHeaderVals []string, vHeader *SingleHeader) result {
+	absent := true
+	for _, reqHeaderVal := range reqHeaderVals {
+		if reqHeaderVal != "" {
+			absent = false
+			break
+		}
+	}
+
+	if vHeader.IsDebug() {
+		debugLog("Header validated", vHeader, strings.Join(reqHeaderVals, ","), slog.String("mode", "absent"), slog.Bool("result", absent))
+	}
+
+	return resultOf(absent)
+}
+
+// checkMaxAge checks whether the header value is a timestamp which is neither older than the max age
+// nor further in the future than the clock skew, values which can not be parsed fail the check
+func checkMaxAge(requestValue *string, vHeader *SingleHeader) bool {
+	if vHeader.maxAge == 0 {
+		return true
+	}
+
+	timestamp, err := parseTimestamp(*requestValue, vHeader.TimeFormat)
+	if err != nil {
+		return false
+	}
+
+	age := vHeader.now().Sub(timestamp)
+	return age <= vHeader.maxAge && age >= -vHeader.clockSkew
+}
+
+// parseTimestamp parses the value with the time format, which is a Go time layout or one of
+// 'rfc3339', 'unix' (seconds), 'unixmilli' and 'http'. Without format RFC 3339 and unix seconds are accepted.
+func parseTimestamp(value string, format string) (time.Time, error) {
+	switch strings.ToLower(format) {
+	case "":
+		if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+			return timestamp, nil
+		}
+		return parseUnix(value, time.Unix)
+	case "rfc3339":
+		return time.Parse(time.RFC3339, value)
+	case "unix":
+		return parseUnix(value, time.Unix)
+	case "unixmilli":
+		return parseUnix(value, func(msec int64, _ int64) time.Time { return time.UnixMilli(msec) })
+	case "http":
+		return http.ParseTime(value)
+	default:
+		return time.Parse(format, value)
+	}
+}
+
+// parseUnix parses an integer timestamp since the unix epoch, the unit is defined by the conversion
+func parseUnix(value string, toTime func(int64, int64) time.Time) (time.Time, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return toTime(n, 0), nil
+}
+
+// checkLength checks whether the number of characters of a header value is within the configured bounds
+// an empty value of a header which is not required is not constrained
+func checkLength(requestValue *string, vHeader *SingleHeader) bool {
+	if vHeader.MinLength == nil && vHeader.MaxLength == nil {
+		return true
+	}
+
+	if *requestValue == "" && vHeader.allowsEmpty() {
+		return true
+	}
+
+	length := utf8.RuneCountInString(*requestValue)
+	if vHeader.MinLength != nil && length < *vHeader.MinLength {
+		return false
+	}
+	if vHeader.MaxLength != nil && length > *vHeader.MaxLength {
+		return false
+	}
+
+	return true
+}
+
+// checkRange checks whether a numeric header value is within the configured bounds
+// non numeric values fail the check, an empty value of a header which is not required is not constrained
+func checkRange(requestValue *string, vHeader *SingleHeader) bool {
+	if vHeader.MinValue == nil && vHeader.MaxValue == nil {
+		return true
+	}
+
+	if *requestValue == "" && vHeader.allowsEmpty() {
+		return true
+	}
+
+	value, err := strconv.ParseFloat(*requestValue, 64)
+	if err != nil || math.IsNaN(value) {
+		return false
+	}
+	if vHeader.MinValue != nil && value < *vHeader.MinValue {
+		return false
+	}
+	if vHeader.MaxValue != nil && value > *vHeader.MaxValue {
+		return false
+	}
+
+	return true
+}
+
+// checkEqualsHeader checks whether the header value equals the value of the configured other header
+// an absent header passes if it is not required, otherwise both headers must be present and equal
+func checkEqualsHeader(req *http.Request, vHeader *SingleHeader) result {
+	reqHeaderVal := req.Header.Get(vHeader.Name)
+	otherHeaderVal := req.Header.Get(vHeader.EqualsHeader)
+
+	var headerResult result
+	if len(req.Header.Values(vHeader.Name)) == 0 {
+		headerResult = checkMissing(vHeader)
+	} else if reqHeaderVal == "" {
+		headerResult = checkRequired(&reqHeaderVal, vHeader)
+	} else if otherHeaderVal != "" {
+		headerResult = resultOf(equalValues(foldCase(reqHeaderVal, vHeader), foldCase(otherHeaderVal, vHeader), vHeader))
+	}
+
+	if vHeader.IsDebug() {
+		debugLog("Header validated", vHeader, reqHeaderVal, slog.String("mode", "equalsheader"), slog.String("equalsHeader", vHeader.EqualsHeader), slog.String("equalsValue", otherHeaderVal), slog.Bool("result", headerResult.passed()), slog.String("outcome", headerResult.String()))
+	}
+
+	return headerResult
+}
+
+// jwtClaim extracts the claim from the payload of a JWT, optionally prefixed with 'Bearer '
+// the signature of the token is NOT verified
+func jwtClaim(token string, claim string) (string, error) {
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = token[7:]
+	}
+
+	segments := strings.Split(strings.TrimSpace(token), ".")
+	if len(segments) != 3 {
+		return "", fmt.Errorf("malformed token, expected 3 segments but got %d", len(segments))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	var claims map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return "", fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	value, ok := claims[claim]
+	if !ok {
+		return "", fmt.Errorf("claim %v not found", claim)
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("claim %v can not be encoded: %w", claim, err)
+		}
+		return string(encoded), nil
+	}
+}
+
+// checkContains checks whether a header value contains the configured value
+func checkContains(requestValue *string, vHeader *SingleHeader) bool {
+	reqValue := foldCase(*requestValue, vHeader)
+	if vHeader.IsDistinctMatches() {
+		return isMatchCountValid(countDistinctContains(reqValue, vHeader), vHeader)
+	}
+
+	matchCount := 0
+	for _, value := range vHeader.Values {
+		if strings.Contains(reqValue, foldCase(value, vHeader)) {
+			matchCount++
+		}
+	}
+
+	return isMatchCountValid(matchCount, vHeader)
+}
+
+// countDistinctContains counts the configured values which occur in the request value at least once
+// outside of an occurrence of a longer configured value, e.g. "ab" is not counted for "abc" if "abc" is configured as well
+func countDistinctContains(reqValue string, vHeader *SingleHeader) int {
+	values := make([]string, len(vHeader.Values))
+	occurrences := make([][]int, len(vHeader.Values))
+	for i, value := range vHeader.Values {
+		values[i] = foldCase(value, vHeader)
+		for start := 0; start < len(reqValue); {
+			index := strings.Index(reqValue[start:], values[i])
+			if index < 0 {
+				break
+			}
+			occurrences[i] = append(occurrences[i], start+index)
+			// overlapping occurrences are collected as well
+			start += index + 1
+		}
+	}
+
+	matchCount := 0
+	for i, value := range values {
+		for _, start := range occurrences[i] {
+			if !isCovered(start, len(value), values, occurrences) {
+				matchCount++
+				break
+			}
+		}
+	}
+
+	return matchCount
+}
+
+// isCovered checks whether the occurrence at start lies within an occurrence of a longer value
+func isCovered(start, length int, values []string, occurrences [][]int) bool {
+	for j, value := range values {
+		if len(value) <= length {
+			continue
+		}
+		for _, other := range occurrences[j] {
+			if other <= start && start+length <= other+len(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkPrefix checks whether a header value starts with the configured value
+func checkPrefix(requestValue *string, vHeader *SingleHeader) bool {
+	return checkAffix(requestValue, vHeader, strings.HasPrefix)
+}
+
+// checkSuffix checks whether a header value ends with the configured value
+func checkSuffix(requestValue *string, vHeader *SingleHeader) bool {
+	return checkAffix(requestValue, vHeader, strings.HasSuffix)
+}
+
+// checkAffix counts the configured values for which hasAffix reports true
+func checkAffix(requestValue *string, vHeader *SingleHeader, hasAffix func(s, affix string) bool) bool {
+	reqValue := foldCase(*requestValue, vHeader)
+	matchCount := 0
+	for _, value := range vHeader.Values {
+		if hasAffix(reqValue, foldCase(value, vHeader)) {
+			matchCount++
+		}
+	}
+
+	return isMatchCountValid(matchCount, vHeader)
+}
+
+// checkRegex checks whether a header value matches the configured regex
+// and stores the capture group of the first matching regex in captured if a capture header is configured
+func checkRegex(requestValue *string, vHeader *SingleHeader, captured *string) bool {
+	if vHeader.CaptureTo == "" {
+		return checkPatterns(requestValue, vHeader)
+	}
+
+	matchCount := 0
+	for _, re := range vHeader.regexes {
+		submatches := re.FindStringSubmatch(*requestValue)
+		if submatches == nil {
+			continue
+		}
+		matchCount++
+		if *captured == "" {
+			*captured = submatches[vHeader.captureGroup()]
+		}
+	}
+
+	return isMatchCountValid(matchCount, vHeader)
+}
+
+// checkGlob checks whether a header value matches the configured glob
+func checkGlob(requestValue *string, vHeader *SingleHeader) bool {
+	return checkPatterns(requestValue, vHeader)
+}
+
+// checkPatterns counts the precompiled patterns matching the header value
+func checkPatterns(requestValue *string, vHeader *SingleHeader) bool {
+	matchCount := 0
+	for _, re := range vHeader.regexes {
+		if re.MatchString(*requestValue) {
+			matchCount++
+		}
+	}
+
+	return isMatchCountValid(matchCount, vHeader)
+}
+
+// checkCIDR checks whether the IPs of a comma separated header value are within the configured networks
+// MatchOne requires one IP, MatchAll every IP and MatchNone no IP to be within one of the networks;
+// the rule fails if the value contains anything which is not an IP
+func checkCIDR(requestValue *string, vHeader *SingleHeader) bool {
+	ips := strings.Split(*requestValue, ",")
+	matchCount := 0
+	for _, value := range ips {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return false
+		}
+
+		for _, network := range vHeader.networks {
+			if network.Contains(ip) {
+				matchCount++
+				break
+			}
+		}
+	}
+
+	switch MatchType(vHeader.MatchType) {
+	case MatchNone:
+		return matchCount == 0
+	case MatchAll:
+		return matchCount == len(ips)
+	default:
+		return matchCount > 0
+	}
+}
+
+// numericCondition is a comparison of the request value with a number
+type numericCondition struct {
+	operator string
+	value    float64
+}
+
+// numericOperators are checked in this order, so the two character operators take precedence
+var numericOperators = []string{">=", "<=", "!=", "==", ">", "<"}
+
+// parseNumericCondition parses a comparison like '>=3', a number without operator is compared for equality
+func parseNumericCondition(condition string) (numericCondition, error) {
+	condition = strings.TrimSpace(condition)
+
+	operator := "=="
+	for _, op := range numericOperators {
+		if strings.HasPrefix(condition, op) {
+			operator = op
+			condition = strings.TrimSpace(condition[len(op):])
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(condition, 64)
+	if err != nil || math.IsNaN(value) {
+		return numericCondition{}, fmt.Errorf("%q is not a number", condition)
+	}
+
+	return numericCondition{operator: operator, value: value}, nil
+}
+
+// matches checks whether the value satisfies the condition
+func (c numericCondition) matches(value float64) bool {
+	switch c.operator {
+	case ">=":
+		return value >= c.value
+	case "<=":
+		return value <= c.value
+	case "!=":
+		return value != c.value
+	case ">":
+		return value > c.value
+	case "<":
+		return value < c.value
+	default:
+		return value == c.value
+	}
+}
+
+// checkNumeric checks the numeric request value against the configured conditions using the match type,
+// the rule fails if the value is not a number regardless of the match type
+func checkNumeric(requestValue *string, vHeader *SingleHeader) bool {
+	value, err := strconv.ParseFloat(*requestValue, 64)
+	if err != nil || math.IsNaN(value) {
+		return false
+	}
+
+	matchCount := 0
+	for _, condition := range vHeader.conditions {
+		if condition.matches(value) {
+			matchCount++
+		}
+	}
+
+	return isMatchCountValid(matchCount, vHeader)
+}
+
+// globToRegex translates a glob into an anchored regular expression
+// '*' matches any sequence and '?' a single character, both except '/', a '\' escapes the next character
+func globToRegex(glob string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '\\':
+			i++
+			if i == len(runes) {
+				return "", fmt.Errorf("trailing escape character in %q", glob)
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+
+	sb.WriteString("$")
+
+	return sb.String(), nil
+}
+
+// equalValues compares the request value with a configured value, in constant time for secret headers
+func equalValues(requestValue, value string, vHeader *SingleHeader) bool {
+	if !vHeader.IsSecret() {
+		return requestValue == value
+	}
+
+	// hashing first makes the comparison independent of the value lengths
+	requestHash := sha256.Sum256([]byte(requestValue))
+	valueHash := sha256.Sum256([]byte(value))
+
+	return subtle.ConstantTimeCompare(requestHash[:], valueHash[:]) == 1
+}
+
+// isMatchCountValid checks the number of matched configured values against the match type
+// or the minimum number of matches if configured
+func isMatchCountValid(matchCount int, vHeader *SingleHeader) bool {
+	if vHeader.MinMatches != nil {
+		return matchCount >= *vHeader.MinMatches
+	}
+
+	if vHeader.MatchType == string(MatchNone) {
+		return matchCount == 0
+	}
+
+	if matchCount == 0 {
+		return false
+	} else if vHeader.MatchType == string(MatchAll) && matchCount != len(vHeader.Values) {
+		return false
+	}
+
+	return true
+}
+
+// checkMissing checks whether the header rule is satisfied by an absent header
+// regardless of the match type, a required header which is not present never passes
+func checkMissing(vHeader *SingleHeader) result {
+	if vHeader.allowsAbsent() {
+		return resultAbsentAllowed
+	}
+
+	return resultFailed
+}
+
+// checkRequired checks whether a header value is required in the request
+// an empty value only passes if the header rule allows empty values
+func checkRequired(requestValue *string, vHeader *SingleHeader) result {
+	if *requestValue == "" {
+		if vHeader.allowsEmpty() {
+			return resultAbsentAllowed
+		}
+		return resultFailed
+	}
+
+	reqValue := foldCase(*requestValue, vHeader)
+	if vHeader.Hash != "" {
+		digest := sha256.Sum256([]byte(reqValue))
+		reqValue = hex.EncodeToString(digest[:])
+	}
+
+	matchCount := countExactMatches(reqValue, vHeader)
+
+	if vHeader.MatchType == string(MatchNone) {
+		return resultOf(matchCount == 0)
+	}
+
+	return resultOf(matchCount > 0)
+}
+
+// countExactMatches counts the configured values equal to the request value
+func countExactMatches(reqValue string, vHeader *SingleHeader) int {
+	// the fetched values include the configured ones
+	if vHeader.remote != nil {
+		if vHeader.remote.contains(reqValue) {
+			return 1
+		}
+		return 0
+	}
+	if vHeader.valueSet != nil {
+		// the values are unique, so at most one of them can be equal to the request value
+		if _, ok := vHeader.valueSet[reqValue]; ok {
+			return 1
+		}
+		return 0
+	}
+
+	matchCount := 0
+	for _, value := range vHeader.Values {
+		if vHeader.Hash != "" {
+			// digests have the same length, so the comparison is constant time
+			if subtle.ConstantTimeCompare([]byte(reqValue), []byte(value)) == 1 {
+				matchCount++
+			}
+			continue
+		}
+		// if the header is required, it should match the configured value
+		if equalValues(reqValue, foldCase(value, vHeader), vHeader) {
+			matchCount++
+		}
+	}
+
+	return matchCount
 }
 
-// Config the plugin configuration.
-type Config struct {
-	Headers []SingleHeader
+// foldCase lower-cases the value when the header is configured to match case insensitive
+func foldCase(value string, vHeader *SingleHeader) string {
+	if !vHeader.IsCaseInsensitive() {
+		return value
+	}
+
+	switch vHeader.FoldLocale {
+	case "unicode":
+		return strings.ToLower(value)
+	case "tr":
+		return strings.ToLowerSpecial(unicode.TurkishCase, value)
+	case "az":
+		return strings.ToLowerSpecial(unicode.AzeriCase, value)
+	default:
+		return asciiLower(value)
+	}
 }
 
-// HeaderMatch demonstrates a HeaderMatch plugin.
-type HeaderMatch struct {
-	next    http.Handler
-	headers []SingleHeader
-	name    string
+// asciiLower lowers the ASCII letters of the value, all other characters are kept as they are
+func asciiLower(value string) string {
+	i := strings.IndexFunc(value, func(r rune) bool { return r >= 'A' && r <= 'Z' })
+	if i < 0 {
+		return value
+	}
+
+	b := []byte(value)
+	for ; i < len(b); i++ {
+		if b[i] >= 'A' && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
 }
 
-// MatchType defines an enum which can be used to specify the match type for the 'contains' config.
-type MatchType string
+// matchMode returns the name of the check which is used to match the header value
+func (s *SingleHeader) matchMode() string {
+	switch {
+	case s.HMAC != nil:
+		return "hmac"
+	case s.IsContains():
+		return "contains"
+	case s.IsPrefix():
+		return "prefix"
+	case s.IsSuffix():
+		return "suffix"
+	case s.IsRegex():
+		return "regex"
+	case s.IsGlob():
+		return "glob"
+	case s.IsCIDR():
+		return "cidr"
+	case s.IsNumeric():
+		return "numeric"
+	default:
+		return "required"
+	}
+}
 
-const (
-	//MatchAll requires all values to be matched
-	MatchAll MatchType = "all"
-	//MatchOne requires only one value to be matched
-	MatchOne MatchType = "one"
-	//MatchNone requires none of the values to be matched
-	MatchNone MatchType = "none"
-)
+// modeFlags returns the names of the set match mode flags, which are mutually exclusive
+func (s *SingleHeader) modeFlags() []string {
+	var modes []string
+	for _, mode := range []struct {
+		name string
+		set  bool
+	}{
+		{"contains", s.IsContains()},
+		{"prefix", s.IsPrefix()},
+		{"suffix", s.IsSuffix()},
+		{"regex", s.IsRegex()},
+		{"glob", s.IsGlob()},
+		{"cidr", s.IsCIDR()},
+		{"numeric", s.IsNumeric()},
+	} {
+		if mode.set {
+			modes = append(modes, mode.name)
+		}
+	}
 
-// CreateConfig creates the default plugin configuration.
-func CreateConfig() *Config {
-	return &Config{
-		Headers: []SingleHeader{},
+	return modes
+}
+
+// isExactMatch checks whether the request value is compared for equality with the configured values,
+// secret and hashed values are excluded as they are compared in constant time
+func (s *SingleHeader) isExactMatch() bool {
+	return s.matchMode() == "required" && !s.IsSecret() && s.Hash == ""
+}
+
+// captureGroup returns the regex capture group forwarded with captureto, defaults to the first group
+func (s *SingleHeader) captureGroup() int {
+	if s.CaptureGroup == nil {
+		return 1
 	}
+
+	return *s.CaptureGroup
 }
 
-// New created a new HeaderMatch plugin.
-func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if len(config.Headers) == 0 {
-		return nil, fmt.Errorf("configuration incorrect, missing headers")
+// ruleKey identifies what the header rule checks, two rules with the same key check the same value the same way
+func (s *SingleHeader) ruleKey() string {
+	source := strings.ToLower(s.Source)
+	if isHeaderSource(s) {
+		source = string(SourceHeader)
+	}
+	splitIndex := 0
+	if s.SplitIndex != nil {
+		splitIndex = *s.SplitIndex
+	}
+	parameter := "-"
+	if s.Parameter != nil {
+		parameter = strings.ToLower(*s.Parameter)
 	}
 
-	for _, vHeader := range config.Headers {
-		if strings.TrimSpace(vHeader.Name) == "" {
-			return nil, fmt.Errorf("configuration incorrect, missing header name")
-		}
-		if len(vHeader.Values) == 0 {
-			return nil, fmt.Errorf("configuration incorrect, missing header values")
-		} else {
-			for _, value := range vHeader.Values {
-				if strings.TrimSpace(value) == "" {
-					return nil, fmt.Errorf("configuration incorrect, empty value found")
-				}
-			}
-		}
-		if !vHeader.IsContains() && vHeader.MatchType == string(MatchAll) {
-			return nil, fmt.Errorf("configuration incorrect for header %v %s", vHeader.Name, ", matchall can only be used in combination with 'contains'")
-		}
-		if strings.TrimSpace(vHeader.MatchType) == "" {
-			return nil, fmt.Errorf("configuration incorrect, missing match type configuration for header %v", vHeader.Name)
-		}
+	return fmt.Sprintf("%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v",
+		source, s.nameKey(), s.matchMode(), s.IsNegate(), s.IsAbsent(),
+		s.JWTClaim, s.BasicAuthField, s.IsSchemePrefix(), s.IsSplitCommas(), parameter, s.SplitBy, splitIndex, s.PathPrefix, s.PathRegex, strings.ToUpper(strings.Join(s.Methods, ",")))
+}
+
+// nameKey returns the name of the header rule as used by ruleKey and scopeKey, raw names are case sensitive
+func (s *SingleHeader) nameKey() string {
+	if s.IsRawName() {
+		return "raw:" + s.Name
 	}
 
-	return &HeaderMatch{
-		headers: config.Headers,
-		next:    next,
-		name:    name,
-	}, nil
+	return strings.ToLower(s.Name)
 }
 
-func (a *HeaderMatch) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	headersValid := true
+// debugSampled decides whether the debug output of an evaluation of the header rule is logged
+func (s *SingleHeader) debugSampled() bool {
+	if s.DebugSampleRate == nil {
+		return true
+	}
 
-	for _, vHeader := range a.headers {
+	return rand.Float64() < *s.DebugSampleRate
+}
 
-		reqHeaderVal := req.Header.Get(vHeader.Name)
+// scopeKey identifies the value the header rule reads and the requests it applies to
+func (s *SingleHeader) scopeKey() string {
+	source := strings.ToLower(s.Source)
+	if isHeaderSource(s) {
+		source = string(SourceHeader)
+	}
 
-		if vHeader.IsURLDecode() {
-			reqHeaderVal, _ = url.QueryUnescape(reqHeaderVal)
-		}
+	return fmt.Sprintf("%v|%v|%v|%v|%v",
+		source, s.nameKey(), s.PathPrefix, s.PathRegex, strings.ToUpper(strings.Join(s.Methods, ",")))
+}
 
-		if reqHeaderVal != "" {
-			if vHeader.IsContains() {
-				headersValid = checkContains(&reqHeaderVal, &vHeader)
-			} else if vHeader.IsRegex() {
-				headersValid = checkRegex(&reqHeaderVal, &vHeader)
-			} else {
-				headersValid = checkRequired(&reqHeaderVal, &vHeader)
-			}
-		} else {
-			headersValid = checkRequired(&reqHeaderVal, &vHeader)
-		}
+// isDenyRule checks whether the header rule rejects requests with a header or header value instead of requiring it
+func (s *SingleHeader) isDenyRule() bool {
+	return s.IsAbsent() || s.IsNegate() || s.MatchType == string(MatchNone)
+}
 
-		if vHeader.IsDebug() {
-			fmt.Println("checkheaders (debug): Headers valid:", headersValid)
-			fmt.Println("checkheaders (debug): Request headers:", reqHeaderVal)
-			fmt.Println("checkheaders (debug): Configured headers:", vHeader.Values)
-		}
+// allowsAbsent checks whether an absent header satisfies the header rule
+func (s *SingleHeader) allowsAbsent() bool {
+	return !s.IsRequired() && !s.IsStrict() && !s.IsPresent()
+}
 
-		if !headersValid {
-			break
-		}
+// allowsEmpty checks whether a present but empty header value satisfies the header rule
+func (s *SingleHeader) allowsEmpty() bool {
+	return s.IsAllowEmpty() && !s.IsStrict() && !s.IsPresent()
+}
+
+// requiresValues checks whether the header rule needs configured values to match against
+func (s *SingleHeader) requiresValues() bool {
+	return !s.IsAbsent() && !s.IsPresent() && s.EqualsHeader == "" && s.MinLength == nil && s.MaxLength == nil && s.MinValue == nil && s.MaxValue == nil && s.MaxAge == "" && s.HMAC == nil
+}
+
+// IsURLDecode checks whether a header value should be url decoded first before testing it
+func (s *SingleHeader) IsURLDecode() bool {
+	if s.URLDecode == nil || !*s.URLDecode {
+		return false
 	}
 
-	if headersValid {
-		a.next.ServeHTTP(rw, req)
-	} else {
-		http.Error(rw, "Not allowed", http.StatusForbidden)
+	return true
+}
+
+// IsBase64Decode checks whether a header value should be base64 decoded (after the optional url decoding) before testing it
+func (s *SingleHeader) IsBase64Decode() bool {
+	if s.Base64Decode == nil || !*s.Base64Decode {
+		return false
 	}
+
+	return true
 }
 
-// checkContains checks whether a header value contains the configured value
-func checkContains(requestValue *string, vHeader *SingleHeader) bool {
+// IsDebug checks whether a header value should print debug information in the log
+func (s *SingleHeader) IsDebug() bool {
+	if s.Debug == nil || !*s.Debug {
+		return false
+	}
 
-	if vHeader.IsDebug() {
-		fmt.Println("checkheaders (debug): Validating contains:", *requestValue, vHeader.Values)
+	return true
+}
+
+// IsContains checks whether a header value should contain the configured value
+func (s *SingleHeader) IsContains() bool {
+	if s.Contains == nil || !*s.Contains {
+		return false
 	}
 
-	matchCount := 0
-	for _, value := range vHeader.Values {
-		if strings.Contains(*requestValue, value) {
-			matchCount++
-		}
+	return true
+}
+
+// IsNormalizeUnicode checks whether decomposed letters should be composed before comparing values
+func (s *SingleHeader) IsNormalizeUnicode() bool {
+	if s.NormalizeUnicode == nil || !*s.NormalizeUnicode {
+		return false
 	}
 
-	if vHeader.MatchType == string(MatchNone) {
-		return matchCount == 0
+	return true
+}
+
+// IsEnabled checks whether the header rule is evaluated, defaults to 'true'
+func (s *SingleHeader) IsEnabled() bool {
+	if s.Enabled == nil || *s.Enabled {
+		return true
 	}
 
-	if matchCount == 0 {
+	return false
+}
+
+// IsRequired checks whether a header is mandatory in the request, defaults to 'true'
+func (s *SingleHeader) IsRequired() bool {
+	if s.Required == nil || *s.Required {
+		return true
+	}
+
+	return false
+}
+
+// IsRegex checks whether a header value should be matched using regular expressions
+func (s *SingleHeader) IsRegex() bool {
+	if s.Regex == nil || !*s.Regex {
 		return false
-	} else if vHeader.MatchType == string(MatchAll) && matchCount != len(vHeader.Values) {
+	}
+
+	return true
+}
+
+// IsCaseInsensitive checks whether a header value should be matched ignoring case
+func (s *SingleHeader) IsCaseInsensitive() bool {
+	if s.CaseInsensitive == nil || !*s.CaseInsensitive {
 		return false
 	}
 
 	return true
 }
 
-// checkRegex checks whether a header value matches the configured regex
-func checkRegex(requestValue *string, vHeader *SingleHeader) bool {
+// IsAllValues checks whether every occurrence of a multi-valued header should be checked instead of only the first
+func (s *SingleHeader) IsAllValues() bool {
+	if s.AllValues == nil || !*s.AllValues {
+		return false
+	}
 
-	if vHeader.IsDebug() {
-		fmt.Println("checkheaders (debug): Validating:", *requestValue, "with regex:", vHeader.Values)
+	return true
+}
+
+// IsNegate checks whether the result of the header rule should be inverted
+func (s *SingleHeader) IsNegate() bool {
+	if s.Negate == nil || !*s.Negate {
+		return false
 	}
 
-	matchCount := 0
-	for _, value := range vHeader.Values {
-		match, err := regexp.MatchString(value, *requestValue)
+	return true
+}
 
-		if err == nil {
-			if match {
-				matchCount++
-			}
-		} else {
-			if vHeader.IsDebug() {
-				fmt.Println("checkheaders (debug): ERROR matching regex:", err)
-			}
-		}
+// IsPrefix checks whether a header value should start with the configured value
+func (s *SingleHeader) IsPrefix() bool {
+	if s.Prefix == nil || !*s.Prefix {
+		return false
+	}
 
+	return true
+}
+
+// IsSuffix checks whether a header value should end with the configured value
+func (s *SingleHeader) IsSuffix() bool {
+	if s.Suffix == nil || !*s.Suffix {
+		return false
 	}
 
-	if vHeader.MatchType == string(MatchNone) {
-		return matchCount == 0
+	return true
+}
+
+// IsTrimSpace checks whether surrounding whitespace should be removed from a header value before testing it
+func (s *SingleHeader) IsTrimSpace() bool {
+	if s.TrimSpace == nil || !*s.TrimSpace {
+		return false
 	}
 
-	if matchCount == 0 {
+	return true
+}
+
+// IsAllowEmpty checks whether a present header with an empty value satisfies the header rule
+func (s *SingleHeader) IsAllowEmpty() bool {
+	if s.AllowEmpty == nil || !*s.AllowEmpty {
 		return false
+	}
 
-	} else if vHeader.MatchType == string(MatchAll) && matchCount != len(vHeader.Values) {
+	return true
+}
+
+// IsPresent checks whether the header has to be present with a non-empty value, configured values are optional then
+func (s *SingleHeader) IsPresent() bool {
+	if s.Present == nil || !*s.Present {
 		return false
 	}
 
 	return true
 }
 
-// checkRequired checks whether a header value is required in the request
-// if the header is not required, it will also return true if the header is not present in the request
-func checkRequired(requestValue *string, vHeader *SingleHeader) bool {
+// IsAbsent checks whether a header must not be present in the request
+func (s *SingleHeader) IsAbsent() bool {
+	if s.Absent == nil || !*s.Absent {
+		return false
+	}
 
-	if vHeader.IsDebug() {
-		fmt.Println("checkheaders (debug): Validating required:", *requestValue, vHeader.Values)
+	return true
+}
+
+// IsSecret checks whether a header value is a secret which should be compared in constant time
+func (s *SingleHeader) IsSecret() bool {
+	if s.Secret == nil || !*s.Secret {
+		return false
 	}
 
-	matchCount := 0
-	for _, value := range vHeader.Values {
-		// if the header is required, it should match the configured value
-		if *requestValue == value {
-			matchCount++
-		}
+	return true
+}
 
-		if !vHeader.IsRequired() && *requestValue == "" {
-			matchCount++
-		}
+// IsDistinctMatches checks whether contained values are only counted if they are not part of a longer matched value
+func (s *SingleHeader) IsDistinctMatches() bool {
+	if s.DistinctMatches == nil || !*s.DistinctMatches {
+		return false
 	}
 
-	if vHeader.MatchType == string(MatchNone) {
-		return matchCount == 0
+	return true
+}
+
+// IsRawName checks whether the header is read with the exact configured name instead of its canonical form
+func (s *SingleHeader) IsRawName() bool {
+	if s.RawName == nil || !*s.RawName {
+		return false
 	}
 
-	if matchCount == 0 {
+	return true
+}
+
+// IsCaseInsensitiveName checks whether query parameter and cookie names should be compared ignoring case
+func (s *SingleHeader) IsCaseInsensitiveName() bool {
+	if s.CaseInsensitiveName == nil || !*s.CaseInsensitiveName {
 		return false
 	}
 
 	return true
 }
 
-// IsURLDecode checks whether a header value should be url decoded first before testing it
-func (s *SingleHeader) IsURLDecode() bool {
-	if s.URLDecode == nil || !*s.URLDecode {
+// IsRegexFullMatch checks whether a regex has to match the whole header value instead of a substring
+func (s *SingleHeader) IsRegexFullMatch() bool {
+	if s.RegexFullMatch == nil || !*s.RegexFullMatch {
 		return false
 	}
 
 	return true
 }
 
-// IsDebug checks whether a header value should print debug information in the log
-func (s *SingleHeader) IsDebug() bool {
-	if s.Debug == nil || !*s.Debug {
+// IsIPNormalize checks whether header values are IPs which should be compared in their canonical form
+func (s *SingleHeader) IsIPNormalize() bool {
+	if s.IPNormalize == nil || !*s.IPNormalize {
 		return false
 	}
 
 	return true
 }
 
-// IsContains checks whether a header value should contain the configured value
-func (s *SingleHeader) IsContains() bool {
-	if s.Contains == nil || !*s.Contains {
+// IsTerminal checks whether the evaluation should stop once the header rule is evaluated, keeping its result
+func (s *SingleHeader) IsTerminal() bool {
+	if s.Terminal == nil || !*s.Terminal {
 		return false
 	}
 
 	return true
 }
 
-// IsRequired checks whether a header is mandatory in the request, defaults to 'true'
-func (s *SingleHeader) IsRequired() bool {
-	if s.Required == nil || *s.Required {
-		return true
+// IsSplitCommas checks whether a header value should be split on commas and each token be validated on its own
+func (s *SingleHeader) IsSplitCommas() bool {
+	if s.SplitCommas == nil || !*s.SplitCommas {
+		return false
 	}
 
-	return false
+	return true
 }
 
-// IsRegex checks whether a header value should be matched using regular expressions
-func (s *SingleHeader) IsRegex() bool {
-	if s.Regex == nil || !*s.Regex {
+// IsSchemePrefix checks whether only the scheme before the first space of a header value should be matched
+func (s *SingleHeader) IsSchemePrefix() bool {
+	if s.SchemePrefix == nil || !*s.SchemePrefix {
+		return false
+	}
+
+	return true
+}
+
+// IsGlob checks whether a header value should be matched using globs with '*' and '?' wildcards
+func (s *SingleHeader) IsGlob() bool {
+	if s.Glob == nil || !*s.Glob {
+		return false
+	}
+
+	return true
+}
+
+// IsCIDR checks whether the configured values are networks in CIDR notation the request IPs should be within
+func (s *SingleHeader) IsCIDR() bool {
+	if s.CIDR == nil || !*s.CIDR {
+		return false
+	}
+
+	return true
+}
+
+// IsNumeric checks whether the configured values are numeric comparisons like '>=3' the request value is tested with
+func (s *SingleHeader) IsNumeric() bool {
+	if s.Numeric == nil || !*s.Numeric {
+		return false
+	}
+
+	return true
+}
+
+// IsRemoveOnPass checks whether a header should be removed from the request before it is forwarded
+func (s *SingleHeader) IsRemoveOnPass() bool {
+	if s.RemoveOnPass == nil || !*s.RemoveOnPass {
+		return false
+	}
+
+	return true
+}
+
+// IsStrict checks whether an empty header value should never satisfy the header rule, even if it is not required
+func (s *SingleHeader) IsStrict() bool {
+	if s.Strict == nil || !*s.Strict {
 		return false
 	}
 
@@ -0,0 +1,533 @@
+package checkheaders
+
+import "strings"
+
+// compositions maps a base letter followed by a combining mark to the precomposed letter.
+// It covers the canonical compositions of the Latin-1 Supplement, Latin Extended-A/B and
+// Latin Extended Additional blocks, which keeps the plugin free of golang.org/x/text.
+var compositions = map[string]rune{
+	"A\u0300":      '\u00c0', // À
+	"A\u0301":      '\u00c1', // Á
+	"A\u0302":      '\u00c2', // Â
+	"A\u0303":      '\u00c3', // Ã
+	"A\u0308":      '\u00c4', // Ä
+	"A\u030a":      '\u00c5', // Å
+	"C\u0327":      '\u00c7', // Ç
+	"E\u0300":      '\u00c8', // È
+	"E\u0301":      '\u00c9', // É
+	"E\u0302":      '\u00ca', // Ê
+	"E\u0308":      '\u00cb', // Ë
+	"I\u0300":      '\u00cc', // Ì
+	"I\u0301":      '\u00cd', // Í
+	"I\u0302":      '\u00ce', // Î
+	"I\u0308":      '\u00cf', // Ï
+	"N\u0303":      '\u00d1', // Ñ
+	"O\u0300":      '\u00d2', // Ò
+	"O\u0301":      '\u00d3', // Ó
+	"O\u0302":      '\u00d4', // Ô
+	"O\u0303":      '\u00d5', // Õ
+	"O\u0308":      '\u00d6', // Ö
+	"U\u0300":      '\u00d9', // Ù
+	"U\u0301":      '\u00da', // Ú
+	"U\u0302":      '\u00db', // Û
+	"U\u0308":      '\u00dc', // Ü
+	"Y\u0301":      '\u00dd', // Ý
+	"a\u0300":      '\u00e0', // à
+	"a\u0301":      '\u00e1', // á
+	"a\u0302":      '\u00e2', // â
+	"a\u0303":      '\u00e3', // ã
+	"a\u0308":      '\u00e4', // ä
+	"a\u030a":      '\u00e5', // å
+	"c\u0327":      '\u00e7', // ç
+	"e\u0300":      '\u00e8', // è
+	"e\u0301":      '\u00e9', // é
+	"e\u0302":      '\u00ea', // ê
+	"e\u0308":      '\u00eb', // ë
+	"i\u0300":      '\u00ec', // ì
+	"i\u0301":      '\u00ed', // í
+	"i\u0302":      '\u00ee', // î
+	"i\u0308":      '\u00ef', // ï
+	"n\u0303":      '\u00f1', // ñ
+	"o\u0300":      '\u00f2', // ò
+	"o\u0301":      '\u00f3', // ó
+	"o\u0302":      '\u00f4', // ô
+	"o\u0303":      '\u00f5', // õ
+	"o\u0308":      '\u00f6', // ö
+	"u\u0300":      '\u00f9', // ù
+	"u\u0301":      '\u00fa', // ú
+	"u\u0302":      '\u00fb', // û
+	"u\u0308":      '\u00fc', // ü
+	"y\u0301":      '\u00fd', // ý
+	"y\u0308":      '\u00ff', // ÿ
+	"A\u0304":      '\u0100', // Ā
+	"a\u0304":      '\u0101', // ā
+	"A\u0306":      '\u0102', // Ă
+	"a\u0306":      '\u0103', // ă
+	"A\u0328":      '\u0104', // Ą
+	"a\u0328":      '\u0105', // ą
+	"C\u0301":      '\u0106', // Ć
+	"c\u0301":      '\u0107', // ć
+	"C\u0302":      '\u0108', // Ĉ
+	"c\u0302":      '\u0109', // ĉ
+	"C\u0307":      '\u010a', // Ċ
+	"c\u0307":      '\u010b', // ċ
+	"C\u030c":      '\u010c', // Č
+	"c\u030c":      '\u010d', // č
+	"D\u030c":      '\u010e', // Ď
+	"d\u030c":      '\u010f', // ď
+	"E\u0304":      '\u0112', // Ē
+	"e\u0304":      '\u0113', // ē
+	"E\u0306":      '\u0114', // Ĕ
+	"e\u0306":      '\u0115', // ĕ
+	"E\u0307":      '\u0116', // Ė
+	"e\u0307":      '\u0117', // ė
+	"E\u0328":      '\u0118', // Ę
+	"e\u0328":      '\u0119', // ę
+	"E\u030c":      '\u011a', // Ě
+	"e\u030c":      '\u011b', // ě
+	"G\u0302":      '\u011c', // Ĝ
+	"g\u0302":      '\u011d', // ĝ
+	"G\u0306":      '\u011e', // Ğ
+	"g\u0306":      '\u011f', // ğ
+	"G\u0307":      '\u0120', // Ġ
+	"g\u0307":      '\u0121', // ġ
+	"G\u0327":      '\u0122', // Ģ
+	"g\u0327":      '\u0123', // ģ
+	"H\u0302":      '\u0124', // Ĥ
+	"h\u0302":      '\u0125', // ĥ
+	"I\u0303":      '\u0128', // Ĩ
+	"i\u0303":      '\u0129', // ĩ
+	"I\u0304":      '\u012a', // Ī
+	"i\u0304":      '\u012b', // ī
+	"I\u0306":      '\u012c', // Ĭ
+	"i\u0306":      '\u012d', // ĭ
+	"I\u0328":      '\u012e', // Į
+	"i\u0328":      '\u012f', // į
+	"I\u0307":      '\u0130', // İ
+	"J\u0302":      '\u0134', // Ĵ
+	"j\u0302":      '\u0135', // ĵ
+	"K\u0327":      '\u0136', // Ķ
+	"k\u0327":      '\u0137', // ķ
+	"L\u0301":      '\u0139', // Ĺ
+	"l\u0301":      '\u013a', // ĺ
+	"L\u0327":      '\u013b', // Ļ
+	"l\u0327":      '\u013c', // ļ
+	"L\u030c":      '\u013d', // Ľ
+	"l\u030c":      '\u013e', // ľ
+	"N\u0301":      '\u0143', // Ń
+	"n\u0301":      '\u0144', // ń
+	"N\u0327":      '\u0145', // Ņ
+	"n\u0327":      '\u0146', // ņ
+	"N\u030c":      '\u0147', // Ň
+	"n\u030c":      '\u0148', // ň
+	"O\u0304":      '\u014c', // Ō
+	"o\u0304":      '\u014d', // ō
+	"O\u0306":      '\u014e', // Ŏ
+	"o\u0306":      '\u014f', // ŏ
+	"O\u030b":      '\u0150', // Ő
+	"o\u030b":      '\u0151', // ő
+	"R\u0301":      '\u0154', // Ŕ
+	"r\u0301":      '\u0155', // ŕ
+	"R\u0327":      '\u0156', // Ŗ
+	"r\u0327":      '\u0157', // ŗ
+	"R\u030c":      '\u0158', // Ř
+	"r\u030c":      '\u0159', // ř
+	"S\u0301":      '\u015a', // Ś
+	"s\u0301":      '\u015b', // ś
+	"S\u0302":      '\u015c', // Ŝ
+	"s\u0302":      '\u015d', // ŝ
+	"S\u0327":      '\u015e', // Ş
+	"s\u0327":      '\u015f', // ş
+	"S\u030c":      '\u0160', // Š
+	"s\u030c":      '\u0161', // š
+	"T\u0327":      '\u0162', // Ţ
+	"t\u0327":      '\u0163', // ţ
+	"T\u030c":      '\u0164', // Ť
+	"t\u030c":      '\u0165', // ť
+	"U\u0303":      '\u0168', // Ũ
+	"u\u0303":      '\u0169', // ũ
+	"U\u0304":      '\u016a', // Ū
+	"u\u0304":      '\u016b', // ū
+	"U\u0306":      '\u016c', // Ŭ
+	"u\u0306":      '\u016d', // ŭ
+	"U\u030a":      '\u016e', // Ů
+	"u\u030a":      '\u016f', // ů
+	"U\u030b":      '\u0170', // Ű
+	"u\u030b":      '\u0171', // ű
+	"U\u0328":      '\u0172', // Ų
+	"u\u0328":      '\u0173', // ų
+	"W\u0302":      '\u0174', // Ŵ
+	"w\u0302":      '\u0175', // ŵ
+	"Y\u0302":      '\u0176', // Ŷ
+	"y\u0302":      '\u0177', // ŷ
+	"Y\u0308":      '\u0178', // Ÿ
+	"Z\u0301":      '\u0179', // Ź
+	"z\u0301":      '\u017a', // ź
+	"Z\u0307":      '\u017b', // Ż
+	"z\u0307":      '\u017c', // ż
+	"Z\u030c":      '\u017d', // Ž
+	"z\u030c":      '\u017e', // ž
+	"O\u031b":      '\u01a0', // Ơ
+	"o\u031b":      '\u01a1', // ơ
+	"U\u031b":      '\u01af', // Ư
+	"u\u031b":      '\u01b0', // ư
+	"A\u030c":      '\u01cd', // Ǎ
+	"a\u030c":      '\u01ce', // ǎ
+	"I\u030c":      '\u01cf', // Ǐ
+	"i\u030c":      '\u01d0', // ǐ
+	"O\u030c":      '\u01d1', // Ǒ
+	"o\u030c":      '\u01d2', // ǒ
+	"U\u030c":      '\u01d3', // Ǔ
+	"u\u030c":      '\u01d4', // ǔ
+	"\u00dc\u0304": '\u01d5', // Ǖ
+	"\u00fc\u0304": '\u01d6', // ǖ
+	"\u00dc\u0301": '\u01d7', // Ǘ
+	"\u00fc\u0301": '\u01d8', // ǘ
+	"\u00dc\u030c": '\u01d9', // Ǚ
+	"\u00fc\u030c": '\u01da', // ǚ
+	"\u00dc\u0300": '\u01db', // Ǜ
+	"\u00fc\u0300": '\u01dc', // ǜ
+	"\u00c4\u0304": '\u01de', // Ǟ
+	"\u00e4\u0304": '\u01df', // ǟ
+	"\u0226\u0304": '\u01e0', // Ǡ
+	"\u0227\u0304": '\u01e1', // ǡ
+	"\u00c6\u0304": '\u01e2', // Ǣ
+	"\u00e6\u0304": '\u01e3', // ǣ
+	"G\u030c":      '\u01e6', // Ǧ
+	"g\u030c":      '\u01e7', // ǧ
+	"K\u030c":      '\u01e8', // Ǩ
+	"k\u030c":      '\u01e9', // ǩ
+	"O\u0328":      '\u01ea', // Ǫ
+	"o\u0328":      '\u01eb', // ǫ
+	"\u01ea\u0304": '\u01ec', // Ǭ
+	"\u01eb\u0304": '\u01ed', // ǭ
+	"\u01b7\u030c": '\u01ee', // Ǯ
+	"\u0292\u030c": '\u01ef', // ǯ
+	"j\u030c":      '\u01f0', // ǰ
+	"G\u0301":      '\u01f4', // Ǵ
+	"g\u0301":      '\u01f5', // ǵ
+	"N\u0300":      '\u01f8', // Ǹ
+	"n\u0300":      '\u01f9', // ǹ
+	"\u00c5\u0301": '\u01fa', // Ǻ
+	"\u00e5\u0301": '\u01fb', // ǻ
+	"\u00c6\u0301": '\u01fc', // Ǽ
+	"\u00e6\u0301": '\u01fd', // ǽ
+	"\u00d8\u0301": '\u01fe', // Ǿ
+	"\u00f8\u0301": '\u01ff', // ǿ
+	"A\u030f":      '\u0200', // Ȁ
+	"a\u030f":      '\u0201', // ȁ
+	"A\u0311":      '\u0202', // Ȃ
+	"a\u0311":      '\u0203', // ȃ
+	"E\u030f":      '\u0204', // Ȅ
+	"e\u030f":      '\u0205', // ȅ
+	"E\u0311":      '\u0206', // Ȇ
+	"e\u0311":      '\u0207', // ȇ
+	"I\u030f":      '\u0208', // Ȉ
+	"i\u030f":      '\u0209', // ȉ
+	"I\u0311":      '\u020a', // Ȋ
+	"i\u0311":      '\u020b', // ȋ
+	"O\u030f":      '\u020c', // Ȍ
+	"o\u030f":      '\u020d', // ȍ
+	"O\u0311":      '\u020e', // Ȏ
+	"o\u0311":      '\u020f', // ȏ
+	"R\u030f":      '\u0210', // Ȑ
+	"r\u030f":      '\u0211', // ȑ
+	"R\u0311":      '\u0212', // Ȓ
+	"r\u0311":      '\u0213', // ȓ
+	"U\u030f":      '\u0214', // Ȕ
+	"u\u030f":      '\u0215', // ȕ
+	"U\u0311":      '\u0216', // Ȗ
+	"u\u0311":      '\u0217', // ȗ
+	"S\u0326":      '\u0218', // Ș
+	"s\u0326":      '\u0219', // ș
+	"T\u0326":      '\u021a', // Ț
+	"t\u0326":      '\u021b', // ț
+	"H\u030c":      '\u021e', // Ȟ
+	"h\u030c":      '\u021f', // ȟ
+	"A\u0307":      '\u0226', // Ȧ
+	"a\u0307":      '\u0227', // ȧ
+	"E\u0327":      '\u0228', // Ȩ
+	"e\u0327":      '\u0229', // ȩ
+	"\u00d6\u0304": '\u022a', // Ȫ
+	"\u00f6\u0304": '\u022b', // ȫ
+	"\u00d5\u0304": '\u022c', // Ȭ
+	"\u00f5\u0304": '\u022d', // ȭ
+	"O\u0307":      '\u022e', // Ȯ
+	"o\u0307":      '\u022f', // ȯ
+	"\u022e\u0304": '\u0230', // Ȱ
+	"\u022f\u0304": '\u0231', // ȱ
+	"Y\u0304":      '\u0232', // Ȳ
+	"y\u0304":      '\u0233', // ȳ
+	"A\u0325":      '\u1e00', // Ḁ
+	"a\u0325":      '\u1e01', // ḁ
+	"B\u0307":      '\u1e02', // Ḃ
+	"b\u0307":      '\u1e03', // ḃ
+	"B\u0323":      '\u1e04', // Ḅ
+	"b\u0323":      '\u1e05', // ḅ
+	"B\u0331":      '\u1e06', // Ḇ
+	"b\u0331":      '\u1e07', // ḇ
+	"\u00c7\u0301": '\u1e08', // Ḉ
+	"\u00e7\u0301": '\u1e09', // ḉ
+	"D\u0307":      '\u1e0a', // Ḋ
+	"d\u0307":      '\u1e0b', // ḋ
+	"D\u0323":      '\u1e0c', // Ḍ
+	"d\u0323":      '\u1e0d', // ḍ
+	"D\u0331":      '\u1e0e', // Ḏ
+	"d\u0331":      '\u1e0f', // ḏ
+	"D\u0327":      '\u1e10', // Ḑ
+	"d\u0327":      '\u1e11', // ḑ
+	"D\u032d":      '\u1e12', // Ḓ
+	"d\u032d":      '\u1e13', // ḓ
+	"\u0112\u0300": '\u1e14', // Ḕ
+	"\u0113\u0300": '\u1e15', // ḕ
+	"\u0112\u0301": '\u1e16', // Ḗ
+	"\u0113\u0301": '\u1e17', // ḗ
+	"E\u032d":      '\u1e18', // Ḙ
+	"e\u032d":      '\u1e19', // ḙ
+	"E\u0330":      '\u1e1a', // Ḛ
+	"e\u0330":      '\u1e1b', // ḛ
+	"\u0228\u0306": '\u1e1c', // Ḝ
+	"\u0229\u0306": '\u1e1d', // ḝ
+	"F\u0307":      '\u1e1e', // Ḟ
+	"f\u0307":      '\u1e1f', // ḟ
+	"G\u0304":      '\u1e20', // Ḡ
+	"g\u0304":      '\u1e21', // ḡ
+	"H\u0307":      '\u1e22', // Ḣ
+	"h\u0307":      '\u1e23', // ḣ
+	"H\u0323":      '\u1e24', // Ḥ
+	"h\u0323":      '\u1e25', // ḥ
+	"H\u0308":      '\u1e26', // Ḧ
+	"h\u0308":      '\u1e27', // ḧ
+	"H\u0327":      '\u1e28', // Ḩ
+	"h\u0327":      '\u1e29', // ḩ
+	"H\u032e":      '\u1e2a', // Ḫ
+	"h\u032e":      '\u1e2b', // ḫ
+	"I\u0330":      '\u1e2c', // Ḭ
+	"i\u0330":      '\u1e2d', // ḭ
+	"\u00cf\u0301": '\u1e2e', // Ḯ
+	"\u00ef\u0301": '\u1e2f', // ḯ
+	"K\u0301":      '\u1e30', // Ḱ
+	"k\u0301":      '\u1e31', // ḱ
+	"K\u0323":      '\u1e32', // Ḳ
+	"k\u0323":      '\u1e33', // ḳ
+	"K\u0331":      '\u1e34', // Ḵ
+	"k\u0331":      '\u1e35', // ḵ
+	"L\u0323":      '\u1e36', // Ḷ
+	"l\u0323":      '\u1e37', // ḷ
+	"\u1e36\u0304": '\u1e38', // Ḹ
+	"\u1e37\u0304": '\u1e39', // ḹ
+	"L\u0331":      '\u1e3a', // Ḻ
+	"l\u0331":      '\u1e3b', // ḻ
+	"L\u032d":      '\u1e3c', // Ḽ
+	"l\u032d":      '\u1e3d', // ḽ
+	"M\u0301":      '\u1e3e', // Ḿ
+	"m\u0301":      '\u1e3f', // ḿ
+	"M\u0307":      '\u1e40', // Ṁ
+	"m\u0307":      '\u1e41', // ṁ
+	"M\u0323":      '\u1e42', // Ṃ
+	"m\u0323":      '\u1e43', // ṃ
+	"N\u0307":      '\u1e44', // Ṅ
+	"n\u0307":      '\u1e45', // ṅ
+	"N\u0323":      '\u1e46', // Ṇ
+	"n\u0323":      '\u1e47', // ṇ
+	"N\u0331":      '\u1e48', // Ṉ
+	"n\u0331":      '\u1e49', // ṉ
+	"N\u032d":      '\u1e4a', // Ṋ
+	"n\u032d":      '\u1e4b', // ṋ
+	"\u00d5\u0301": '\u1e4c', // Ṍ
+	"\u00f5\u0301": '\u1e4d', // ṍ
+	"\u00d5\u0308": '\u1e4e', // Ṏ
+	"\u00f5\u0308": '\u1e4f', // ṏ
+	"\u014c\u0300": '\u1e50', // Ṑ
+	"\u014d\u0300": '\u1e51', // ṑ
+	"\u014c\u0301": '\u1e52', // Ṓ
+	"\u014d\u0301": '\u1e53', // ṓ
+	"P\u0301":      '\u1e54', // Ṕ
+	"p\u0301":      '\u1e55', // ṕ
+	"P\u0307":      '\u1e56', // Ṗ
+	"p\u0307":      '\u1e57', // ṗ
+	"R\u0307":      '\u1e58', // Ṙ
+	"r\u0307":      '\u1e59', // ṙ
+	"R\u0323":      '\u1e5a', // Ṛ
+	"r\u0323":      '\u1e5b', // ṛ
+	"\u1e5a\u0304": '\u1e5c', // Ṝ
+	"\u1e5b\u0304": '\u1e5d', // ṝ
+	"R\u0331":      '\u1e5e', // Ṟ
+	"r\u0331":      '\u1e5f', // ṟ
+	"S\u0307":      '\u1e60', // Ṡ
+	"s\u0307":      '\u1e61', // ṡ
+	"S\u0323":      '\u1e62', // Ṣ
+	"s\u0323":      '\u1e63', // ṣ
+	"\u015a\u0307": '\u1e64', // Ṥ
+	"\u015b\u0307": '\u1e65', // ṥ
+	"\u0160\u0307": '\u1e66', // Ṧ
+	"\u0161\u0307": '\u1e67', // ṧ
+	"\u1e62\u0307": '\u1e68', // Ṩ
+	"\u1e63\u0307": '\u1e69', // ṩ
+	"T\u0307":      '\u1e6a', // Ṫ
+	"t\u0307":      '\u1e6b', // ṫ
+	"T\u0323":      '\u1e6c', // Ṭ
+	"t\u0323":      '\u1e6d', // ṭ
+	"T\u0331":      '\u1e6e', // Ṯ
+	"t\u0331":      '\u1e6f', // ṯ
+	"T\u032d":      '\u1e70', // Ṱ
+	"t\u032d":      '\u1e71', // ṱ
+	"U\u0324":      '\u1e72', // Ṳ
+	"u\u0324":      '\u1e73', // ṳ
+	"U\u0330":      '\u1e74', // Ṵ
+	"u\u0330":      '\u1e75', // ṵ
+	"U\u032d":      '\u1e76', // Ṷ
+	"u\u032d":      '\u1e77', // ṷ
+	"\u0168\u0301": '\u1e78', // Ṹ
+	"\u0169\u0301": '\u1e79', // ṹ
+	"\u016a\u0308": '\u1e7a', // Ṻ
+	"\u016b\u0308": '\u1e7b', // ṻ
+	"V\u0303":      '\u1e7c', // Ṽ
+	"v\u0303":      '\u1e7d', // ṽ
+	"V\u0323":      '\u1e7e', // Ṿ
+	"v\u0323":      '\u1e7f', // ṿ
+	"W\u0300":      '\u1e80', // Ẁ
+	"w\u0300":      '\u1e81', // ẁ
+	"W\u0301":      '\u1e82', // Ẃ
+	"w\u0301":      '\u1e83', // ẃ
+	"W\u0308":      '\u1e84', // Ẅ
+	"w\u0308":      '\u1e85', // ẅ
+	"W\u0307":      '\u1e86', // Ẇ
+	"w\u0307":      '\u1e87', // ẇ
+	"W\u0323":      '\u1e88', // Ẉ
+	"w\u0323":      '\u1e89', // ẉ
+	"X\u0307":      '\u1e8a', // Ẋ
+	"x\u0307":      '\u1e8b', // ẋ
+	"X\u0308":      '\u1e8c', // Ẍ
+	"x\u0308":      '\u1e8d', // ẍ
+	"Y\u0307":      '\u1e8e', // Ẏ
+	"y\u0307":      '\u1e8f', // ẏ
+	"Z\u0302":      '\u1e90', // Ẑ
+	"z\u0302":      '\u1e91', // ẑ
+	"Z\u0323":      '\u1e92', // Ẓ
+	"z\u0323":      '\u1e93', // ẓ
+	"Z\u0331":      '\u1e94', // Ẕ
+	"z\u0331":      '\u1e95', // ẕ
+	"h\u0331":      '\u1e96', // ẖ
+	"t\u0308":      '\u1e97', // ẗ
+	"w\u030a":      '\u1e98', // ẘ
+	"y\u030a":      '\u1e99', // ẙ
+	"\u017f\u0307": '\u1e9b', // ẛ
+	"A\u0323":      '\u1ea0', // Ạ
+	"a\u0323":      '\u1ea1', // ạ
+	"A\u0309":      '\u1ea2', // Ả
+	"a\u0309":      '\u1ea3', // ả
+	"\u00c2\u0301": '\u1ea4', // Ấ
+	"\u00e2\u0301": '\u1ea5', // ấ
+	"\u00c2\u0300": '\u1ea6', // Ầ
+	"\u00e2\u0300": '\u1ea7', // ầ
+	"\u00c2\u0309": '\u1ea8', // Ẩ
+	"\u00e2\u0309": '\u1ea9', // ẩ
+	"\u00c2\u0303": '\u1eaa', // Ẫ
+	"\u00e2\u0303": '\u1eab', // ẫ
+	"\u1ea0\u0302": '\u1eac', // Ậ
+	"\u1ea1\u0302": '\u1ead', // ậ
+	"\u0102\u0301": '\u1eae', // Ắ
+	"\u0103\u0301": '\u1eaf', // ắ
+	"\u0102\u0300": '\u1eb0', // Ằ
+	"\u0103\u0300": '\u1eb1', // ằ
+	"\u0102\u0309": '\u1eb2', // Ẳ
+	"\u0103\u0309": '\u1eb3', // ẳ
+	"\u0102\u0303": '\u1eb4', // Ẵ
+	"\u0103\u0303": '\u1eb5', // ẵ
+	"\u1ea0\u0306": '\u1eb6', // Ặ
+	"\u1ea1\u0306": '\u1eb7', // ặ
+	"E\u0323":      '\u1eb8', // Ẹ
+	"e\u0323":      '\u1eb9', // ẹ
+	"E\u0309":      '\u1eba', // Ẻ
+	"e\u0309":      '\u1ebb', // ẻ
+	"E\u0303":      '\u1ebc', // Ẽ
+	"e\u0303":      '\u1ebd', // ẽ
+	"\u00ca\u0301": '\u1ebe', // Ế
+	"\u00ea\u0301": '\u1ebf', // ế
+	"\u00ca\u0300": '\u1ec0', // Ề
+	"\u00ea\u0300": '\u1ec1', // ề
+	"\u00ca\u0309": '\u1ec2', // Ể
+	"\u00ea\u0309": '\u1ec3', // ể
+	"\u00ca\u0303": '\u1ec4', // Ễ
+	"\u00ea\u0303": '\u1ec5', // ễ
+	"\u1eb8\u0302": '\u1ec6', // Ệ
+	"\u1eb9\u0302": '\u1ec7', // ệ
+	"I\u0309":      '\u1ec8', // Ỉ
+	"i\u0309":      '\u1ec9', // ỉ
+	"I\u0323":      '\u1eca', // Ị
+	"i\u0323":      '\u1ecb', // ị
+	"O\u0323":      '\u1ecc', // Ọ
+	"o\u0323":      '\u1ecd', // ọ
+	"O\u0309":      '\u1ece', // Ỏ
+	"o\u0309":      '\u1ecf', // ỏ
+	"\u00d4\u0301": '\u1ed0', // Ố
+	"\u00f4\u0301": '\u1ed1', // ố
+	"\u00d4\u0300": '\u1ed2', // Ồ
+	"\u00f4\u0300": '\u1ed3', // ồ
+	"\u00d4\u0309": '\u1ed4', // Ổ
+	"\u00f4\u0309": '\u1ed5', // ổ
+	"\u00d4\u0303": '\u1ed6', // Ỗ
+	"\u00f4\u0303": '\u1ed7', // ỗ
+	"\u1ecc\u0302": '\u1ed8', // Ộ
+	"\u1ecd\u0302": '\u1ed9', // ộ
+	"\u01a0\u0301": '\u1eda', // Ớ
+	"\u01a1\u0301": '\u1edb', // ớ
+	"\u01a0\u0300": '\u1edc', // Ờ
+	"\u01a1\u0300": '\u1edd', // ờ
+	"\u01a0\u0309": '\u1ede', // Ở
+	"\u01a1\u0309": '\u1edf', // ở
+	"\u01a0\u0303": '\u1ee0', // Ỡ
+	"\u01a1\u0303": '\u1ee1', // ỡ
+	"\u01a0\u0323": '\u1ee2', // Ợ
+	"\u01a1\u0323": '\u1ee3', // ợ
+	"U\u0323":      '\u1ee4', // Ụ
+	"u\u0323":      '\u1ee5', // ụ
+	"U\u0309":      '\u1ee6', // Ủ
+	"u\u0309":      '\u1ee7', // ủ
+	"\u01af\u0301": '\u1ee8', // Ứ
+	"\u01b0\u0301": '\u1ee9', // ứ
+	"\u01af\u0300": '\u1eea', // Ừ
+	"\u01b0\u0300": '\u1eeb', // ừ
+	"\u01af\u0309": '\u1eec', // Ử
+	"\u01b0\u0309": '\u1eed', // ử
+	"\u01af\u0303": '\u1eee', // Ữ
+	"\u01b0\u0303": '\u1eef', // ữ
+	"\u01af\u0323": '\u1ef0', // Ự
+	"\u01b0\u0323": '\u1ef1', // ự
+	"Y\u0300":      '\u1ef2', // Ỳ
+	"y\u0300":      '\u1ef3', // ỳ
+	"Y\u0323":      '\u1ef4', // Ỵ
+	"y\u0323":      '\u1ef5', // ỵ
+	"Y\u0309":      '\u1ef6', // Ỷ
+	"y\u0309":      '\u1ef7', // ỷ
+	"Y\u0303":      '\u1ef8', // Ỹ
+	"y\u0303":      '\u1ef9', // ỹ
+}
+
+// normalizeUnicode composes decomposed Latin letters like "e\u0301" into their precomposed form "\u00e9",
+// matching the NFC normalization for these letters. Other characters are kept as they are.
+func normalizeUnicode(value string) string {
+	// values without combining marks (U+0300 to U+036F) are already composed
+	if !strings.ContainsFunc(value, isCombiningMark) {
+		return value
+	}
+
+	composed := make([]rune, 0, len(value))
+	for _, r := range value {
+		if last := len(composed) - 1; last >= 0 && isCombiningMark(r) {
+			if precomposed, ok := compositions[string(composed[last])+string(r)]; ok {
+				composed[last] = precomposed
+				continue
+			}
+		}
+		composed = append(composed, r)
+	}
+
+	return string(composed)
+}
+
+// isCombiningMark checks whether the rune is one of the combining diacritical marks
+func isCombiningMark(r rune) bool {
+	return r >= 0x0300 && r <= 0x036f
+}